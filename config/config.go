@@ -49,13 +49,14 @@ type Config struct {
 	MaxOrphanTxs     int     `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
 	MinTxFee         int64   `long:"mintxfee" description:"The minimum transaction fee in AtomMEER/kB."`
 	// Miner
-	Generate          bool     `long:"generate" description:"Generate (mine) coins using the CPU"`
-	MiningAddrs       []string `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
-	MiningTimeOffset  int      `long:"miningtimeoffset" description:"Offset the mining timestamp of a block by this many seconds (positive values are in the past)"`
-	BlockMinSize      uint32   `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
-	BlockMaxSize      uint32   `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
-	BlockPrioritySize uint32   `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
-	miningAddrs       []types.Address
+	Generate           bool     `long:"generate" description:"Generate (mine) coins using the CPU"`
+	MiningAddrs        []string `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
+	MiningTimeOffset   int      `long:"miningtimeoffset" description:"Offset the mining timestamp of a block by this many seconds (positive values are in the past)"`
+	BlockMinSize       uint32   `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
+	BlockMaxSize       uint32   `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
+	BlockPrioritySize  uint32   `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	CoinbaseExtraNonce int      `long:"coinbaseextranonce" description:"Size in bytes of the extra nonce region reserved in the coinbase of block templates"`
+	miningAddrs        []types.Address
 	//WebSocket support
 	RPCMaxWebsockets int `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
 	//P2P
@@ -87,6 +88,18 @@ type Config struct {
 
 	// Cache Invalid tx
 	CacheInvalidTx bool `long:"cacheinvalidtx" description:"Cache invalid transactions."`
+
+	// Index catch up rate
+	IndexCatchUpBlocks   int           `long:"indexcatchupblocks" description:"Maximum number of blocks indexed per --indexcatchupinterval while catching up the indexes, such as after dropping them (0 for no limit)"`
+	IndexCatchUpInterval time.Duration `long:"indexcatchupinterval" description:"Interval over which --indexcatchupblocks blocks may be indexed"`
+
+	// Transaction index options
+	TxIndexCache        int     `long:"txindexcache" description:"Number of recently looked up transaction locations the transaction index keeps in memory (0 to disable)"`
+	TxIndexStrict       bool    `long:"txindexstrict" description:"Refuse to connect a block with a transaction whose hash is already indexed for another block"`
+	TxIndexSideChain    bool    `long:"txindexsidechain" description:"Keep the transaction index entries of blocks disconnected from the main chain"`
+	TxIndexCompact      bool    `long:"txindexcompact" description:"Store new transaction index entries in the smaller compact format"`
+	TxIndexFilter       bool    `long:"txindexfilter" description:"Keep a bloom filter of the indexed transactions in memory to answer lookups of unknown transactions without reading the database"`
+	TxIndexFilterFPRate float64 `long:"txindexfilterfprate" description:"False positive rate of the --txindexfilter bloom filter (0 for the default)"`
 }
 
 func (c *Config) GetMinningAddrs() []types.Address {
@@ -438,44 +438,29 @@ func (b *BlockChain) initChainState(interrupt <-chan struct{}) error {
 		}
 		log.Info(fmt.Sprintf("Dag loaded:loadTime=%v", time.Since(bidxStart)))
 
-		// Determine how many blocks will be loaded into the index in order to
-		// allocate the right amount as a single alloc versus a whole bunch of
-		// littles ones to reduce pressure on the GC.
-		var block *types.SerializedBlock
-		for i := uint(0); i < uint(state.total); i++ {
-			blockHash := b.bd.GetBlockHash(i)
-			block, err = dbFetchBlockByHash(dbTx, blockHash)
-			if err != nil {
-				return err
-			}
-			if i != 0 && block.Block().Header.GetVersion() != b.BlockVersion {
-				return fmt.Errorf("The dag block is not match current genesis block. you can cleanup your block data base by '--cleanup'.")
-			}
-			parents := []*blockNode{}
-			for _, pb := range block.Block().Parents {
-				parent := b.index.LookupNode(pb)
-				if parent == nil {
-					return fmt.Errorf("Can't find parent %s", pb.String())
-				}
-				parents = append(parents, parent)
-			}
-			refblock := b.bd.GetBlockById(i)
-			//
-			node := &blockNode{}
-			initBlockNode(node, &block.Block().Header, parents)
-			b.index.addNode(node)
-			node.status = BlockStatus(refblock.GetStatus())
-			node.SetOrder(uint64(refblock.GetOrder()))
-			node.SetHeight(refblock.GetHeight())
-			node.dagID = i
-			if i != 0 {
-				node.CalcWorkSum(node.GetMainParent(b))
-			}
+		// Load the block index from the DAG block entries and headers
+		// in batches rather than loading every full block.
+		err = b.index.LoadFromDB(dbTx, blockIndexLoadBatchSize)
+		if err != nil {
+			return err
+		}
+		versionMismatch := false
+		b.index.ForEach(func(node *blockNode) bool {
+			versionMismatch = node.hasParents() &&
+				node.blockVersion != b.BlockVersion
+			return !versionMismatch
+		})
+		if versionMismatch {
+			return fmt.Errorf("The dag block is not match current genesis block. you can cleanup your block data base by '--cleanup'.")
 		}
 
 		// Set the best chain view to the stored best state.
 		// Load the raw block bytes for the best block.
 		mainTip := b.index.LookupNode(b.bd.GetMainChainTip().GetHash())
+		block, err := dbFetchBlockByHash(dbTx, mainTip.GetHash())
+		if err != nil {
+			return err
+		}
 		// Initialize the state related to the best block.
 		blockSize := uint64(block.Block().SerializeSize())
 		numTxns := uint64(len(block.Block().Transactions))
@@ -1121,6 +1106,11 @@ func (b *BlockChain) BlockIndex() *blockIndex {
 	return b.index
 }
 
+// Return the chain parameters
+func (b *BlockChain) ChainParams() *params.Params {
+	return b.params
+}
+
 // Return median time source
 func (b *BlockChain) TimeSource() MedianTimeSource {
 	return b.timeSource
@@ -1141,7 +1131,11 @@ func (b *BlockChain) getReorganizeNodes(newNode *blockNode, block *types.Seriali
 				oldOrdersTemp = append(oldOrdersTemp, refnode.Clone())
 			}
 		}
-		refnode.SetOrder(uint64(refblock.GetOrder()))
+		if refnode == newNode {
+			refnode.SetOrder(uint64(refblock.GetOrder()))
+		} else {
+			b.index.SetNodeOrder(refnode, uint64(refblock.GetOrder()))
+		}
 	}
 	if newOrders.Len() <= 1 || len(oldOrdersTemp) == 0 {
 		return
@@ -2,11 +2,17 @@
 package blockchain
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/merkle"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/params"
+	"math/big"
+	"sort"
 	"sync"
 )
 
@@ -33,6 +39,38 @@ type IndexManager interface {
 	IsDuplicateTx(tx database.Tx, txid *hash.Hash, blockHash *hash.Hash) bool
 }
 
+// blockIndexShards is the number of shards the nodes of the block index are
+// spread across by the first byte of their hash.  It must be a power of two.
+const blockIndexShards = 16
+
+// blockIndexShard holds the nodes of the block index whose hash falls into the
+// shard.  Its index and pruned maps are only modified while holding both the
+// block index lock and the shard lock for writes, so they can be read while
+// holding either of them.  The loaded and loading maps only ever need the
+// shard lock.
+type blockIndexShard struct {
+	sync.RWMutex
+	index map[hash.Hash]*blockNode
+
+	// pruned records every node of the shard removed by PruneBelow.
+	pruned map[hash.Hash]*prunedNode
+
+	// loaded holds the pruned nodes LookupNode has loaded back from the
+	// database until the next PruneBelow, and loading has a channel for
+	// every pruned node that is being loaded, which is closed once it is
+	// done, so each node is only loaded once.
+	loaded  map[hash.Hash]*blockNode
+	loading map[hash.Hash]chan struct{}
+}
+
+// prunedNode records what is needed to load a node removed by PruneBelow back
+// from the database.  The work sum is kept since it depends on the main parent
+// of the node, which isn't loaded along with it.
+type prunedNode struct {
+	id      uint
+	workSum *big.Int
+}
+
 // blockIndex provides facilities for keeping track of an in-memory index of the
 // block chain.  Although the name block chain suggests a single chain of
 // blocks, it is actually a tree-shaped structure where any node can have
@@ -45,21 +83,220 @@ type blockIndex struct {
 	db     database.DB
 	params *params.Params
 
+	// The nodes are sharded by hash so lookups by hash only take the lock
+	// of a single shard and don't contend with the block index lock, which
+	// protects everything else including the mutable fields of the nodes.
+	shards [blockIndexShards]blockIndexShard
+
 	sync.RWMutex
-	index map[hash.Hash]*blockNode
 	dirty map[*blockNode]struct{}
+
+	// orders maps the DAG order of every ordered node to that node so
+	// blocks can be looked up by order without walking the DAG.
+	orders map[uint64]*blockNode
 }
 
 // newBlockIndex returns a new empty instance of a block index.  The index will
 // be dynamically populated as block nodes are loaded from the database and
 // manually added.
 func newBlockIndex(db database.DB, par *params.Params) *blockIndex {
-	return &blockIndex{
+	bi := &blockIndex{
 		db:     db,
 		params: par,
-		index:  make(map[hash.Hash]*blockNode),
 		dirty:  make(map[*blockNode]struct{}),
+		orders: make(map[uint64]*blockNode),
+	}
+	for i := range bi.shards {
+		bi.shards[i].index = make(map[hash.Hash]*blockNode)
+		bi.shards[i].pruned = make(map[hash.Hash]*prunedNode)
+		bi.shards[i].loaded = make(map[hash.Hash]*blockNode)
+		bi.shards[i].loading = make(map[hash.Hash]chan struct{})
+	}
+	return bi
+}
+
+// Params returns the chain parameters the block index was created with.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) Params() *params.Params {
+	return bi.params
+}
+
+// shard returns the shard holding the node with the passed hash.
+func (bi *blockIndex) shard(hash *hash.Hash) *blockIndexShard {
+	return &bi.shards[hash[0]&(blockIndexShards-1)]
+}
+
+// forEachNode invokes fn for every node in the block index, stopping early when
+// fn returns false.
+//
+// This function MUST be called with the block index lock held (for reads).
+func (bi *blockIndex) forEachNode(fn func(*blockNode) bool) {
+	for i := range bi.shards {
+		for _, node := range bi.shards[i].index {
+			if !fn(node) {
+				return
+			}
+		}
+	}
+}
+
+// numNodes returns the number of nodes in the block index.
+//
+// This function MUST be called with the block index lock held (for reads).
+func (bi *blockIndex) numNodes() int {
+	count := 0
+	for i := range bi.shards {
+		count += len(bi.shards[i].index)
 	}
+	return count
+}
+
+// blockIndexLoadBatchSize is the number of blocks whose headers LoadFromDB
+// fetches at once while the chain state is initialized.
+const blockIndexLoadBatchSize = 2000
+
+// LoadFromDB populates the block index from the DAG block entries stored in the
+// block index bucket.  The entries are read in batches of batchSize blocks and
+// the headers of each batch are fetched with a single FetchBlockHeaders call,
+// which is much cheaper than loading every full block.  The parents of every
+// block are always stored before it, so each node is linked to its parents as
+// it is reconstructed, in the order given by orderParents.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) LoadFromDB(dbTx database.Tx, batchSize int) error {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.BlockIndexBucketName)
+	if bucket == nil {
+		return fmt.Errorf("block index bucket does not exist")
+	}
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	bi.Lock()
+	defer bi.Unlock()
+
+	byID := make(map[uint]*blockNode)
+	var serializedID [4]byte
+	for id := uint32(0); ; {
+		// Decode the next batch of DAG blocks.
+		dagBlocks := make([]*blockdag.Block, 0, batchSize)
+		hashes := make([]hash.Hash, 0, batchSize)
+		for ; len(dagBlocks) < batchSize; id++ {
+			dbnamespace.ByteOrder.PutUint32(serializedID[:], id)
+			data := bucket.Get(serializedID[:])
+			if data == nil {
+				break
+			}
+			dagBlock := &blockdag.Block{}
+			err := dagBlock.Decode(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("unable to decode dag block %d: %v",
+					id, err)
+			}
+			dagBlocks = append(dagBlocks, dagBlock)
+			hashes = append(hashes, *dagBlock.GetHash())
+		}
+		if len(dagBlocks) == 0 {
+			return nil
+		}
+
+		headers, err := dbTx.FetchBlockHeaders(hashes)
+		if err != nil {
+			return err
+		}
+		for i, dagBlock := range dagBlocks {
+			var header types.BlockHeader
+			err := header.Deserialize(bytes.NewReader(headers[i]))
+			if err != nil {
+				return fmt.Errorf("unable to deserialize header of "+
+					"block %s: %v", hashes[i], err)
+			}
+
+			var parents []*blockNode
+			if dagBlock.GetParents() != nil {
+				for _, pid := range dagBlock.GetParents().List() {
+					parent, ok := byID[pid]
+					if !ok {
+						return fmt.Errorf("missing parent %d of "+
+							"block %s", pid, hashes[i])
+					}
+					parents = append(parents, parent)
+				}
+			}
+			var mainParent *blockNode
+			if len(parents) > 0 {
+				var ok bool
+				mainParent, ok = byID[dagBlock.GetMainParent()]
+				if !ok {
+					return fmt.Errorf("missing main parent of "+
+						"block %s", hashes[i])
+				}
+				parents, err = orderParents(dbTx, &hashes[i],
+					&header, parents, mainParent)
+				if err != nil {
+					return err
+				}
+			}
+
+			node := &blockNode{}
+			initBlockNode(node, &header, parents)
+			node.status = BlockStatus(dagBlock.GetStatus())
+			node.SetOrder(uint64(dagBlock.GetOrder()))
+			node.SetHeight(dagBlock.GetHeight())
+			node.SetLayer(dagBlock.GetLayer())
+			node.dagID = dagBlock.GetID()
+			if mainParent != nil {
+				node.CalcWorkSum(mainParent)
+			}
+			byID[node.dagID] = node
+			bi.addNode(node)
+		}
+		if len(dagBlocks) < batchSize {
+			return nil
+		}
+	}
+}
+
+// orderParents returns the passed parents of the block with the passed hash and
+// header in the order the block lists them, which the parent root of its header
+// depends on, since the DAG block entry only records them as a set.  Templates
+// list the main parent first followed by the other parents sorted by hash, so
+// the block is only loaded to learn the order when that doesn't match.
+func orderParents(dbTx database.Tx, blockHash *hash.Hash, header *types.BlockHeader, parents []*blockNode, mainParent *blockNode) ([]*blockNode, error) {
+	if len(parents) < 2 {
+		return parents, nil
+	}
+	sort.Slice(parents, func(i, j int) bool {
+		if (parents[i] == mainParent) != (parents[j] == mainParent) {
+			return parents[i] == mainParent
+		}
+		return parents[i].hash.String() < parents[j].hash.String()
+	})
+	parentHashes := make([]*hash.Hash, len(parents))
+	byHash := make(map[hash.Hash]*blockNode, len(parents))
+	for i, parent := range parents {
+		parentHashes[i] = &parent.hash
+		byHash[parent.hash] = parent
+	}
+	if merkle.CalcParentsRoot(parentHashes).IsEqual(&header.ParentRoot) {
+		return parents, nil
+	}
+
+	block, err := dbFetchBlockByHash(dbTx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	ordered := make([]*blockNode, 0, len(parents))
+	for _, h := range block.Block().Parents {
+		parent, ok := byHash[*h]
+		if !ok {
+			return nil, fmt.Errorf("parent %s of block %s is not in "+
+				"its dag block entry", h, blockHash)
+		}
+		ordered = append(ordered, parent)
+	}
+	return ordered, nil
 }
 
 // lookupNode returns the block node identified by the provided hash.  It will
@@ -67,26 +304,140 @@ func newBlockIndex(db database.DB, par *params.Params) *blockIndex {
 //
 // This function MUST be called with the block index lock held (for reads).
 func (bi *blockIndex) lookupNode(hash *hash.Hash) *blockNode {
-	return bi.index[*hash]
+	return bi.shard(hash).index[*hash]
 }
 
 // LookupNode returns the block node identified by the provided hash.  It will
-// return nil if there is no entry for the hash.
+// return nil if there is no entry for the hash.  Only the lock of the shard
+// holding the hash is taken, so it may be called while holding the block index
+// lock.  A node removed by PruneBelow is loaded back from the database without
+// its parent nodes, see loadPrunedNode, and kept until the next PruneBelow.
 //
 // This function is safe for concurrent access.
 func (bi *blockIndex) LookupNode(hash *hash.Hash) *blockNode {
-	bi.RLock()
-	node := bi.lookupNode(hash)
-	bi.RUnlock()
-	return node
+	shard := bi.shard(hash)
+	for {
+		shard.RLock()
+		node := shard.index[*hash]
+		if node == nil {
+			node = shard.loaded[*hash]
+		}
+		entry := shard.pruned[*hash]
+		shard.RUnlock()
+		if node != nil || entry == nil || bi.db == nil {
+			return node
+		}
+
+		// Wait for the node when another caller is already loading it
+		// and look it up again afterwards.
+		shard.Lock()
+		if node = shard.loaded[*hash]; node != nil {
+			shard.Unlock()
+			return node
+		}
+		if wait, ok := shard.loading[*hash]; ok {
+			shard.Unlock()
+			<-wait
+			continue
+		}
+		done := make(chan struct{})
+		shard.loading[*hash] = done
+		shard.Unlock()
+
+		err := bi.db.View(func(dbTx database.Tx) error {
+			var err error
+			node, err = loadPrunedNode(dbTx, hash, entry)
+			return err
+		})
+
+		shard.Lock()
+		delete(shard.loading, *hash)
+		if err == nil && shard.pruned[*hash] == entry {
+			shard.loaded[*hash] = node
+		}
+		shard.Unlock()
+		close(done)
+		if err != nil {
+			log.Error(fmt.Sprintf("Unable to load pruned block %s: %v",
+				hash, err))
+			return nil
+		}
+		return node
+	}
+}
+
+// dbFetchDAGBlock fetches the DAG block entry with the passed ID from the
+// block index bucket.
+func dbFetchDAGBlock(bucket database.Bucket, id uint) (*blockdag.Block, error) {
+	var serializedID [4]byte
+	dbnamespace.ByteOrder.PutUint32(serializedID[:], uint32(id))
+	data := bucket.Get(serializedID[:])
+	if data == nil {
+		return nil, fmt.Errorf("missing dag block %d", id)
+	}
+	dagBlock := &blockdag.Block{}
+	if err := dagBlock.Decode(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("unable to decode dag block %d: %v", id,
+			err)
+	}
+	return dagBlock, nil
+}
+
+// loadPrunedNode loads the node with the passed hash, which was removed by
+// PruneBelow, back from the database the same way LoadFromDB does.  The parents
+// of the node are linked by ID rather than loaded as well, and the work sum is
+// the one recorded when the node was pruned.
+func loadPrunedNode(dbTx database.Tx, h *hash.Hash, entry *prunedNode) (*blockNode, error) {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.BlockIndexBucketName)
+	if bucket == nil {
+		return nil, fmt.Errorf("block index bucket does not exist")
+	}
+	dagBlock, err := dbFetchDAGBlock(bucket, entry.id)
+	if err != nil {
+		return nil, err
+	}
+	serializedHeader, err := dbTx.FetchBlockHeader(h)
+	if err != nil {
+		return nil, err
+	}
+	var header types.BlockHeader
+	err = header.Deserialize(bytes.NewReader(serializedHeader))
+	if err != nil {
+		return nil, fmt.Errorf("unable to deserialize header of block "+
+			"%s: %v", h, err)
+	}
+
+	node := &blockNode{}
+	initBlockNode(node, &header, nil)
+	node.status = BlockStatus(dagBlock.GetStatus())
+	node.SetOrder(uint64(dagBlock.GetOrder()))
+	node.SetHeight(dagBlock.GetHeight())
+	node.SetLayer(dagBlock.GetLayer())
+	node.dagID = dagBlock.GetID()
+	node.workSum = new(big.Int).Set(entry.workSum)
+	if dagBlock.GetParents() != nil && !dagBlock.GetParents().IsEmpty() {
+		node.prunedParents = &prunedParents{
+			ids:  dagBlock.GetParents().List(),
+			root: header.ParentRoot,
+		}
+	}
+	return node, nil
 }
 
 // addNode adds the provided node to the block index.  Duplicate entries are not
-// checked so it is up to caller to avoid adding them.
+// checked so it is up to caller to avoid adding them.  The node's work sum must
+// already include the work sum of its main parent, see CalcWorkSum, since the
+// main parent is chosen by the DAG rather than the index.
 //
 // This function MUST be called with the block index lock held (for writes).
 func (bi *blockIndex) addNode(node *blockNode) {
-	bi.index[node.hash] = node
+	shard := bi.shard(&node.hash)
+	shard.Lock()
+	shard.index[node.hash] = node
+	shard.Unlock()
+	if node.IsOrdered() {
+		bi.orders[node.order] = node
+	}
 }
 
 // AddNode adds the provided node to the block index.  Duplicate entries are not
@@ -100,14 +451,12 @@ func (bi *blockIndex) AddNode(node *blockNode) {
 	bi.Unlock()
 }
 
-// HaveBlock returns whether or not the block index contains the provided hash.
+// HaveBlock returns whether or not the block index contains the provided hash,
+// including blocks removed by PruneBelow.
 //
 // This function is safe for concurrent access.
 func (bi *blockIndex) HaveBlock(hash *hash.Hash) bool {
-	bi.RLock()
-	_, hasBlock := bi.index[*hash]
-	bi.RUnlock()
-	return hasBlock
+	return bi.LookupNode(hash) != nil
 }
 
 // NodeStatus returns the status associated with the provided node.
@@ -120,6 +469,412 @@ func (bi *blockIndex) NodeStatus(node *blockNode) BlockStatus {
 	return status
 }
 
+// WorkSum returns a copy of the total amount of work in the chain up to and
+// including the passed node.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) WorkSum(node *blockNode) *big.Int {
+	bi.RLock()
+	workSum := new(big.Int).Set(node.workSum)
+	bi.RUnlock()
+	return workSum
+}
+
+// FindForkPoint returns the node for the first hash in the passed block
+// locator that exists in the index, including blocks removed by PruneBelow, or
+// the genesis node when none of them do.
+// See BlockLocator for details on the algorithm used to create a block locator.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) FindForkPoint(locator []*hash.Hash) *blockNode {
+	for _, h := range locator {
+		if node := bi.LookupNode(h); node != nil {
+			return node
+		}
+	}
+	return bi.LookupNode(bi.params.GenesisHash)
+}
+
+// ForEach invokes fn for every node in the block index while holding the read
+// lock, stopping early when fn returns false.  The callback may use LookupNode
+// and HaveBlock, but must not call anything else that takes the block index
+// lock or it will deadlock.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) ForEach(fn func(*blockNode) bool) {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	bi.forEachNode(fn)
+}
+
+// PruneBelow removes fully validated nodes whose order is below the passed
+// order from the in-memory index, along with their ancestors, and returns how
+// many were removed.  Nodes at or above the order are kept, as are nodes that
+// aren't ordered or fully validated yet, nodes with unflushed changes, the DAG
+// tips passed in tips, since new blocks and templates build on them, and all
+// descendants of kept nodes, so no kept node loses a child.  The parents of
+// kept nodes form the frontier that is kept as well, but the frontier nodes
+// are only linked to their own parents by ID so everything behind them can be
+// released.  Pruned blocks remain in the database and LookupNode loads them
+// back on demand.
+//
+// Since the parents of the frontier nodes change, this function MUST be called
+// with the chain lock held so nothing walks the parents concurrently.
+func (bi *blockIndex) PruneBelow(order uint32, tips *blockdag.HashSet) int {
+	bi.Lock()
+	defer bi.Unlock()
+
+	keep := make(map[*blockNode]struct{})
+	children := make(map[*blockNode][]*blockNode)
+	var queue []*blockNode
+	bi.forEachNode(func(node *blockNode) bool {
+		for _, parent := range node.parents {
+			children[parent] = append(children[parent], node)
+		}
+		if !node.IsOrdered() || node.order >= uint64(order) ||
+			!node.status.KnownValid() || node.dirty ||
+			(tips != nil && tips.Has(&node.hash)) {
+			keep[node] = struct{}{}
+			queue = append(queue, node)
+		}
+		return true
+	})
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, child := range children[node] {
+			if _, ok := keep[child]; !ok {
+				keep[child] = struct{}{}
+				queue = append(queue, child)
+			}
+		}
+	}
+	frontier := make(map[*blockNode]struct{})
+	for node := range keep {
+		for _, parent := range node.parents {
+			if _, ok := keep[parent]; !ok {
+				frontier[parent] = struct{}{}
+			}
+		}
+	}
+
+	var prune []*blockNode
+	bi.forEachNode(func(node *blockNode) bool {
+		_, kept := keep[node]
+		_, onFrontier := frontier[node]
+		if !kept && !onFrontier {
+			prune = append(prune, node)
+		}
+		return true
+	})
+	for node := range frontier {
+		node.detachParents()
+	}
+	for _, node := range prune {
+		if cur, ok := bi.orders[node.order]; ok && cur == node {
+			delete(bi.orders, node.order)
+		}
+		shard := bi.shard(&node.hash)
+		shard.Lock()
+		delete(shard.index, node.hash)
+		shard.pruned[node.hash] = &prunedNode{
+			id:      node.dagID,
+			workSum: node.workSum,
+		}
+		shard.Unlock()
+		delete(bi.dirty, node)
+	}
+	for i := range bi.shards {
+		shard := &bi.shards[i]
+		shard.Lock()
+		shard.loaded = make(map[hash.Hash]*blockNode)
+		shard.Unlock()
+	}
+	return len(prune)
+}
+
+// EquivocationsAt returns the nodes at the passed height grouped by the script
+// their coinbase pays to, keeping only the groups with more than one block.
+// Such groups are competing blocks produced by the same miner.  Blocks whose
+// data can't be loaded are left out of the result.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) EquivocationsAt(height uint64) [][]*blockNode {
+	var nodes []*blockNode
+	bi.ForEach(func(node *blockNode) bool {
+		if uint64(node.height) == height {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+	if len(nodes) < 2 {
+		return nil
+	}
+
+	groups := make(map[string][]*blockNode)
+	var keys []string
+	bi.db.View(func(dbTx database.Tx) error {
+		for _, node := range nodes {
+			block, err := dbFetchBlockByHash(dbTx, &node.hash)
+			if err != nil {
+				log.Trace(fmt.Sprintf("Unable to load block %s: %v",
+					node.hash, err))
+				continue
+			}
+			txns := block.Block().Transactions
+			if len(txns) == 0 || len(txns[0].TxOut) == 0 {
+				continue
+			}
+			key := string(txns[0].TxOut[0].PkScript)
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], node)
+		}
+		return nil
+	})
+
+	var result [][]*blockNode
+	for _, key := range keys {
+		if len(groups[key]) > 1 {
+			result = append(result, groups[key])
+		}
+	}
+	return result
+}
+
+// setNodeOrder sets the order of the passed node and keeps the order mapping
+// in sync with it.
+//
+// This function MUST be called with the block index lock held (for writes).
+func (bi *blockIndex) setNodeOrder(node *blockNode, order uint64) {
+	if cur, ok := bi.orders[node.order]; ok && cur == node {
+		delete(bi.orders, node.order)
+	}
+	node.SetOrder(order)
+	if node.IsOrdered() {
+		bi.orders[order] = node
+	}
+}
+
+// SetNodeOrder sets the order of the passed node and keeps the order mapping
+// in sync with it.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) SetNodeOrder(node *blockNode, order uint64) {
+	bi.Lock()
+	bi.setNodeOrder(node, order)
+	bi.Unlock()
+}
+
+// MainChainByOrder returns up to count nodes in main chain order beginning with
+// the node at startOrder.  Fewer nodes are returned when the end of the chain
+// is reached.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) MainChainByOrder(startOrder, count uint32) []*blockNode {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	size := int(count)
+	if size > len(bi.orders) {
+		size = len(bi.orders)
+	}
+	nodes := make([]*blockNode, 0, size)
+	for i := uint64(0); i < uint64(count); i++ {
+		node, ok := bi.orders[uint64(startOrder)+i]
+		if !ok {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// CompareNodes defines the canonical order of block nodes used to pick the best
+// tip of the block index, such as the one StaleTips measures the other tips
+// against.  Nodes with more accumulated work sort first, then nodes earlier in
+// the DAG order and finally nodes with the lower hash, so every node that knows
+// the same blocks agrees on the best tip.  It returns a negative number when a
+// sorts before b, a positive number when b sorts before a and zero only when
+// both are the same block.
+//
+// The main chain tip is not chosen this way.  It is decided by the ordering of
+// the block DAG, see BlockDAG.GetMainChainTip, which is part of consensus.
+func CompareNodes(a, b *blockNode) int {
+	if cmp := a.workSum.Cmp(b.workSum); cmp != 0 {
+		return -cmp
+	}
+	if a.order != b.order {
+		if a.order < b.order {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a.hash[:], b.hash[:])
+}
+
+// tipNodes returns all nodes of the block index that are not the parent of
+// another node in the index, ordered by CompareNodes.
+//
+// This function MUST be called with the block index lock held (for reads).
+func (bi *blockIndex) tipNodes() []*blockNode {
+	hasChildren := make(map[*blockNode]struct{}, bi.numNodes())
+	bi.forEachNode(func(node *blockNode) bool {
+		for _, parent := range node.parents {
+			hasChildren[parent] = struct{}{}
+		}
+		return true
+	})
+
+	var tips []*blockNode
+	bi.forEachNode(func(node *blockNode) bool {
+		if _, ok := hasChildren[node]; !ok {
+			tips = append(tips, node)
+		}
+		return true
+	})
+	sort.Slice(tips, func(i, j int) bool {
+		return CompareNodes(tips[i], tips[j]) < 0
+	})
+	return tips
+}
+
+// DAGLocator returns a block locator covering every branch of the DAG rather
+// than only the main chain.  It starts with the passed DAG tips, as returned by
+// BlockDAG.GetTips, that are in the index and not known to be invalid, ordered
+// by CompareNodes, followed by the
+// ancestors of each tip in turn, found by following the parent with the
+// highest order.  As with BlockLocator, the 10 closest ancestors are included
+// and then the distance doubles with each entry.  The ancestors of a tip stop
+// at the first block that is already in the locator, since the branches the
+// tips share are covered from there on, so no hash is listed twice.  The
+// genesis block always comes last.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) DAGLocator(tipSet *blockdag.HashSet) []*hash.Hash {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	tips := make([]*blockNode, 0, tipSet.Size())
+	for _, h := range tipSet.List() {
+		tip := bi.lookupNode(h)
+		if tip != nil && !tip.status.KnownInvalid() {
+			tips = append(tips, tip)
+		}
+	}
+	sort.Slice(tips, func(i, j int) bool {
+		return CompareNodes(tips[i], tips[j]) < 0
+	})
+
+	var genesis *blockNode
+	seen := make(map[*blockNode]struct{})
+	locator := make([]*hash.Hash, 0, len(tips))
+	for _, tip := range tips {
+		seen[tip] = struct{}{}
+		locator = append(locator, &tip.hash)
+	}
+	for _, tip := range tips {
+		node, step := tip, 1
+		for ancestors := 1; ; ancestors++ {
+			// Move back step blocks, stopping at the genesis block.
+			for i := 0; i < step; i++ {
+				parent := node.GetBackParent()
+				if parent == nil {
+					break
+				}
+				node = parent
+			}
+			if !node.hasParents() {
+				genesis = node
+				break
+			}
+			if _, ok := seen[node]; ok {
+				break
+			}
+			seen[node] = struct{}{}
+			locator = append(locator, &node.hash)
+
+			// Once 10 ancestors of the tip have been included, start
+			// doubling the distance between included hashes.
+			if ancestors >= 10 && step < 1<<30 {
+				step *= 2
+			}
+		}
+	}
+	if genesis == nil && bi.params != nil {
+		genesis = bi.LookupNode(bi.params.GenesisHash)
+	}
+	if _, ok := seen[genesis]; genesis != nil && !ok {
+		locator = append(locator, &genesis.hash)
+	}
+	return locator
+}
+
+// StaleTips returns all tips of the block index whose height is more than
+// heightThreshold below the height of the best tip, ordered by CompareNodes.
+// A tip is any node that is not the parent of another node in the index, and
+// the best tip is the one that sorts first by CompareNodes.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) StaleTips(heightThreshold uint64) []*blockNode {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	tips := bi.tipNodes()
+	if len(tips) == 0 {
+		return nil
+	}
+
+	var stale []*blockNode
+	bestHeight := uint64(tips[0].height)
+	for _, tip := range tips[1:] {
+		height := uint64(tip.height)
+		if height < bestHeight && bestHeight-height > heightThreshold {
+			stale = append(stale, tip)
+		}
+	}
+	return stale
+}
+
+// InvalidTips returns all tips of the block index that are known to be
+// invalid, ordered by CompareNodes.  Invalid blocks never join the DAG, so
+// these are not among the tips returned by BlockDAG.GetTips.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) InvalidTips() []*blockNode {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	var invalid []*blockNode
+	for _, tip := range bi.tipNodes() {
+		if tip.status.KnownInvalid() {
+			invalid = append(invalid, tip)
+		}
+	}
+	return invalid
+}
+
+// CompareAndSwapStatus sets the status of the passed node to new only when its
+// current status is old.  It returns whether the status was changed.  Unlike a
+// NodeStatus call followed by SetStatusFlags, the check and the update happen
+// under a single lock so concurrent transitions can't interleave.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) CompareAndSwapStatus(node *blockNode, old, new BlockStatus) bool {
+	bi.Lock()
+	defer bi.Unlock()
+
+	if node.status != old {
+		return false
+	}
+	node.status = new
+	node.dirty = true
+	bi.dirty[node] = struct{}{}
+	return true
+}
+
 // This function can get backward block hash from list.
 func (bi *blockIndex) GetMaxOrderFromList(list []*hash.Hash) *hash.Hash {
 	var maxOrder uint64 = 0
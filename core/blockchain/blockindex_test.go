@@ -0,0 +1,532 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBlockNode returns a block node with a hash derived from the passed id.
+func testBlockNode(id uint64) *blockNode {
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], id)
+	return &blockNode{hash: hash.DoubleHashH(seed[:]), order: id}
+}
+
+// TestBlockIndexShards ensures nodes spread across the shards can be looked
+// up.
+func TestBlockIndexShards(t *testing.T) {
+	bi := newBlockIndex(nil, nil)
+	nodes := make([]*blockNode, 256)
+	for i := range nodes {
+		nodes[i] = testBlockNode(uint64(i))
+		nodes[i].status = statusValid
+		bi.AddNode(nodes[i])
+	}
+	for i, node := range nodes {
+		if got := bi.LookupNode(&node.hash); got != node {
+			t.Fatalf("LookupNode(%d): got %v, want %v", i, got, node)
+		}
+	}
+	count := 0
+	bi.ForEach(func(*blockNode) bool {
+		count++
+		return true
+	})
+	if count != len(nodes) {
+		t.Fatalf("ForEach: visited %d nodes, want %d", count, len(nodes))
+	}
+}
+
+// testLinkedDAG returns a linked DAG fixture of numBlocks blocks whose DAG
+// block ID, order and height equal their position.  Every block builds on the
+// previous one and every fourth block also merges the block before that, so
+// every block but the last has children.
+func testLinkedDAG(numBlocks int) []*types.Block {
+	blocks := make([]*types.Block, numBlocks)
+	for i := range blocks {
+		var parents []*hash.Hash
+		if i > 0 {
+			h := blocks[i-1].BlockHash()
+			parents = append(parents, &h)
+		}
+		if i >= 2 && i%4 == 0 {
+			h := blocks[i-2].BlockHash()
+			parents = append(parents, &h)
+		}
+		var parentRoot hash.Hash
+		if len(parents) > 0 {
+			parentRoot = *merkle.CalcParentsRoot(parents)
+		}
+		blocks[i] = &types.Block{
+			Header: types.BlockHeader{
+				ParentRoot: parentRoot,
+				Timestamp:  time.Unix(1530833717+int64(i), 0),
+				Difficulty: 0x207fffff,
+				Pow:        pow.GetInstance(pow.BLAKE2BD, 0, []byte{}),
+			},
+			Parents: parents,
+		}
+	}
+	return blocks
+}
+
+// testParentIDs returns the DAG block IDs of the parents of the block at the
+// passed position of the testLinkedDAG fixture.
+func testParentIDs(i int) []uint {
+	var ids []uint
+	if i > 0 {
+		ids = append(ids, uint(i-1))
+	}
+	if i >= 2 && i%4 == 0 {
+		ids = append(ids, uint(i-2))
+	}
+	return ids
+}
+
+// newTestDAGIndex returns a block index holding a fully validated node for
+// every block of the passed testLinkedDAG fixture.
+func newTestDAGIndex(db database.DB, blocks []*types.Block) (*blockIndex, []*blockNode) {
+	bi := newBlockIndex(db, nil)
+	nodes := make([]*blockNode, len(blocks))
+	for i, block := range blocks {
+		var parents []*blockNode
+		for _, id := range testParentIDs(i) {
+			parents = append(parents, nodes[id])
+		}
+		nodes[i] = newBlockNode(&block.Header, parents)
+		nodes[i].status = statusValid
+		nodes[i].SetOrder(uint64(i))
+		nodes[i].SetHeight(uint(i))
+		nodes[i].dagID = uint(i)
+		if i > 0 {
+			nodes[i].CalcWorkSum(nodes[i-1])
+		}
+		bi.AddNode(nodes[i])
+	}
+	return bi, nodes
+}
+
+// storeTestDAG stores the blocks of the passed testLinkedDAG fixture along with
+// their DAG block entries in the block index bucket.
+func storeTestDAG(db database.DB, blocks []*types.Block) error {
+	return db.Update(func(dbTx database.Tx) error {
+		bucket, err := dbTx.Metadata().CreateBucketIfNotExists(
+			dbnamespace.BlockIndexBucketName)
+		if err != nil {
+			return err
+		}
+		for i, block := range blocks {
+			err := dbTx.StoreBlock(types.NewBlock(block))
+			if err != nil {
+				return err
+			}
+
+			// Serialize the entry the same way blockdag.Block does.
+			h := block.BlockHash()
+			parents := testParentIDs(i)
+			mainParent := uint32(blockdag.MaxId)
+			if i > 0 {
+				mainParent = uint32(i - 1)
+			}
+			var buf bytes.Buffer
+			err = s.WriteElements(&buf, uint32(i), &h,
+				uint32(len(parents)))
+			if err != nil {
+				return err
+			}
+			for _, id := range parents {
+				if err := s.WriteElements(&buf, uint32(id)); err != nil {
+					return err
+				}
+			}
+			err = s.WriteElements(&buf, mainParent, uint64(i),
+				uint32(i), uint32(i), uint32(i), byte(statusValid))
+			if err != nil {
+				return err
+			}
+			var serializedID [4]byte
+			dbnamespace.ByteOrder.PutUint32(serializedID[:], uint32(i))
+			if err := bucket.Put(serializedID[:], buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// newTestDB creates an empty database in a temporary directory and returns it
+// along with a function that closes and removes it.
+func newTestDB(t *testing.T) (database.DB, func()) {
+	dir, err := ioutil.TempDir("", "blockindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dir, "db"),
+		params.PrivNetParams.Net)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestPruneBelow ensures PruneBelow releases whole ancestor sets of a linked
+// DAG, keeps the frontier the retained nodes build on linked by ID and leaves
+// the remaining tips alone.
+func TestPruneBelow(t *testing.T) {
+	blocks := testLinkedDAG(40)
+	bi, nodes := newTestDAGIndex(nil, blocks)
+
+	// Add a stale valid tip forking off block 3.
+	stale := newBlockNode(&types.BlockHeader{
+		Timestamp:  time.Unix(1, 0),
+		Difficulty: 0x207fffff,
+		Pow:        pow.GetInstance(pow.BLAKE2BD, 0, []byte{}),
+	}, []*blockNode{nodes[3]})
+	stale.status = statusValid
+	stale.SetOrder(4)
+	bi.AddNode(stale)
+	root := nodes[18].Header().ParentRoot
+
+	// Blocks 20 and up are kept, block 20 merges blocks 19 and 18, so
+	// those form the frontier and everything else below is pruned.
+	tips := blockdag.NewHashSet()
+	tips.Add(&nodes[39].hash)
+	if pruned := bi.PruneBelow(20, tips); pruned != 19 {
+		t.Fatalf("PruneBelow: pruned %d nodes, want 19", pruned)
+	}
+	for i, node := range nodes {
+		if want := i >= 18; bi.HaveBlock(&node.hash) != want {
+			t.Fatalf("HaveBlock(%d): got %v, want %v", i, !want, want)
+		}
+	}
+	if bi.HaveBlock(&stale.hash) {
+		t.Fatal("PruneBelow: kept the stale tip")
+	}
+	for _, i := range []int{18, 19} {
+		node := nodes[i]
+		if node.parents != nil || !node.hasParents() ||
+			!reflect.DeepEqual(node.GetParents(), testParentIDs(i)) {
+			t.Fatalf("PruneBelow: frontier node %d not linked by ID", i)
+		}
+	}
+	if got := nodes[18].Header().ParentRoot; got != root {
+		t.Fatalf("PruneBelow: parent root changed to %v, want %v", got,
+			root)
+	}
+	if len(nodes[20].parents) != 2 || nodes[20].prunedParents != nil {
+		t.Fatal("PruneBelow: unlinked a retained node")
+	}
+
+	bi.RLock()
+	tipNodes := bi.tipNodes()
+	bi.RUnlock()
+	if len(tipNodes) != 1 || tipNodes[0] != nodes[39] {
+		t.Fatalf("PruneBelow: tips are %v, want only the last block",
+			tipNodes)
+	}
+
+	// Pruning again below the same order removes nothing.
+	if pruned := bi.PruneBelow(20, tips); pruned != 0 {
+		t.Fatalf("PruneBelow: pruned %d nodes again, want 0", pruned)
+	}
+}
+
+// TestLookupPrunedNode ensures nodes removed by PruneBelow are loaded back from
+// the database once, even by concurrent callers and by callers holding the
+// block index lock.
+func TestLookupPrunedNode(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	blocks := testLinkedDAG(40)
+	if err := storeTestDAG(db, blocks); err != nil {
+		t.Fatal(err)
+	}
+	bi, nodes := newTestDAGIndex(db, blocks)
+	tips := blockdag.NewHashSet()
+	tips.Add(&nodes[39].hash)
+	if pruned := bi.PruneBelow(20, tips); pruned != 18 {
+		t.Fatalf("PruneBelow: pruned %d nodes, want 18", pruned)
+	}
+
+	want := nodes[12]
+	loaded := make([]*blockNode, 8)
+	var wg sync.WaitGroup
+	for i := range loaded {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loaded[i] = bi.LookupNode(&want.hash)
+		}(i)
+	}
+	wg.Wait()
+	got := loaded[0]
+	if got == nil || got == want {
+		t.Fatalf("LookupNode: got %v, want a node loaded from the "+
+			"database", got)
+	}
+	for _, node := range loaded[1:] {
+		if node != got {
+			t.Fatal("LookupNode: loaded the same node more than once")
+		}
+	}
+	header := got.Header()
+	if got.hash != want.hash || got.order != want.order ||
+		got.height != want.height || got.dagID != want.dagID ||
+		got.status != want.status || got.workSum.Cmp(want.workSum) != 0 ||
+		!reflect.DeepEqual(got.GetParents(), testParentIDs(12)) ||
+		header.BlockHash() != want.hash {
+		t.Fatal("LookupNode: loaded node does not match the pruned one")
+	}
+
+	// Looking up a pruned node must not take the block index lock.
+	bi.ForEach(func(*blockNode) bool {
+		if !bi.HaveBlock(&nodes[3].hash) {
+			t.Fatal("HaveBlock: missing pruned block")
+		}
+		return false
+	})
+}
+
+// TestLoadFromDB ensures the block index loaded from the DAG block entries of a
+// database matches the index the blocks were stored from, whatever the batch
+// size.
+func TestLoadFromDB(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	blocks := testLinkedDAG(40)
+	if err := storeTestDAG(db, blocks); err != nil {
+		t.Fatal(err)
+	}
+	_, want := newTestDAGIndex(nil, blocks)
+	for _, batchSize := range []int{1, 7, 40, 100} {
+		bi := newBlockIndex(db, nil)
+		err := db.View(func(dbTx database.Tx) error {
+			return bi.LoadFromDB(dbTx, batchSize)
+		})
+		if err != nil {
+			t.Fatalf("LoadFromDB(%d): %v", batchSize, err)
+		}
+		for i, node := range want {
+			got := bi.LookupNode(&node.hash)
+			if got == nil {
+				t.Fatalf("LoadFromDB(%d): missing block %d",
+					batchSize, i)
+			}
+			header := got.Header()
+			if got.order != node.order || got.height != node.height ||
+				got.layer != uint(i) || got.dagID != node.dagID ||
+				header.BlockHash() != node.hash ||
+				got.status != node.status ||
+				got.workSum.Cmp(node.workSum) != 0 ||
+				!reflect.DeepEqual(got.GetParents(), node.GetParents()) {
+				t.Fatalf("LoadFromDB(%d): block %d does not match",
+					batchSize, i)
+			}
+			for j, parent := range got.parents {
+				if parent != bi.LookupNode(&node.parents[j].hash) {
+					t.Fatalf("LoadFromDB(%d): block %d not "+
+						"linked to its parents", batchSize, i)
+				}
+			}
+		}
+		if nodes := bi.MainChainByOrder(0, 100); len(nodes) != len(want) {
+			t.Fatalf("LoadFromDB(%d): %d nodes by order, want %d",
+				batchSize, len(nodes), len(want))
+		}
+	}
+}
+
+// TestDAGLocator ensures the DAG locator starts with every tip, covers each
+// branch back to the genesis block and lists no hash twice.
+func TestDAGLocator(t *testing.T) {
+	bi := newBlockIndex(nil, nil)
+	workSum := func(n int64) *big.Int { return big.NewInt(n) }
+
+	// Build a main chain of 40 blocks with a side branch of 3 blocks
+	// forking off block 30 and an invalid tip forking off block 35.
+	chain := make([]*blockNode, 40)
+	for i := range chain {
+		chain[i] = testBlockNode(uint64(i))
+		chain[i].workSum = workSum(int64(i))
+		if i > 0 {
+			chain[i].parents = []*blockNode{chain[i-1]}
+		}
+		bi.AddNode(chain[i])
+	}
+	parent := chain[30]
+	var side *blockNode
+	for i := 0; i < 3; i++ {
+		side = testBlockNode(uint64(100 + i))
+		side.order = parent.order + 1
+		side.workSum = workSum(int64(31 + i))
+		side.parents = []*blockNode{parent}
+		bi.AddNode(side)
+		parent = side
+	}
+	invalid := testBlockNode(200)
+	invalid.workSum = workSum(100)
+	invalid.parents = []*blockNode{chain[35]}
+	invalid.status = statusInvalid
+	bi.AddNode(invalid)
+
+	tips := blockdag.NewHashSet()
+	tips.AddList([]*hash.Hash{&chain[39].hash, &side.hash, &invalid.hash})
+	locator := bi.DAGLocator(tips)
+	if len(locator) < 2 || !locator[0].IsEqual(&chain[39].hash) ||
+		!locator[1].IsEqual(&side.hash) {
+		t.Fatalf("DAGLocator: does not start with the valid tips: %v",
+			locator)
+	}
+	seen := make(map[hash.Hash]struct{})
+	for _, h := range locator {
+		if _, ok := seen[*h]; ok {
+			t.Fatalf("DAGLocator: duplicate hash %v", h)
+		}
+		seen[*h] = struct{}{}
+	}
+	if _, ok := seen[invalid.hash]; ok {
+		t.Fatal("DAGLocator: includes the invalid tip")
+	}
+	if !locator[len(locator)-1].IsEqual(&chain[0].hash) {
+		t.Fatal("DAGLocator: does not end with the genesis block")
+	}
+	for _, node := range []*blockNode{chain[38], chain[29], side.parents[0]} {
+		if _, ok := seen[node.hash]; !ok {
+			t.Fatalf("DAGLocator: missing close ancestor %v", node.hash)
+		}
+	}
+}
+
+// TestCompareNodes ensures nodes are ordered by work, then DAG order and then
+// hash, and that StaleTips measures against the tip that sorts first.
+func TestCompareNodes(t *testing.T) {
+	newNode := func(id uint64, work int64, order uint64) *blockNode {
+		node := testBlockNode(id)
+		node.workSum = big.NewInt(work)
+		node.order = order
+		return node
+	}
+	more := newNode(1, 10, 5)
+	earlier := newNode(2, 9, 3)
+	later := newNode(3, 9, 4)
+	lowHash, highHash := newNode(4, 8, 6), newNode(5, 8, 6)
+	if bytes.Compare(lowHash.hash[:], highHash.hash[:]) > 0 {
+		lowHash, highHash = highHash, lowHash
+	}
+	want := []*blockNode{more, earlier, later, lowHash, highHash}
+	for i, a := range want {
+		for j, b := range want {
+			got := CompareNodes(a, b)
+			if i < j && got >= 0 || i > j && got <= 0 || i == j && got != 0 {
+				t.Fatalf("CompareNodes(%d, %d) = %d", i, j, got)
+			}
+		}
+	}
+
+	// Every insertion order yields the same tips and the same best tip.
+	genesis := newNode(100, 0, 0)
+	for i, node := range want {
+		node.height = uint(i)
+	}
+	more.height = 20
+	for _, perm := range [][]int{{0, 1, 2, 3, 4}, {4, 3, 2, 1, 0},
+		{2, 4, 0, 3, 1}} {
+		bi := newBlockIndex(nil, nil)
+		bi.AddNode(genesis)
+		for _, k := range perm {
+			want[k].parents = []*blockNode{genesis}
+			bi.AddNode(want[k])
+		}
+		bi.RLock()
+		tips := bi.tipNodes()
+		bi.RUnlock()
+		if !reflect.DeepEqual(tips, want) {
+			t.Fatalf("tipNodes(%v): wrong order", perm)
+		}
+
+		// Every tip but the best one is lower than it, so all of them
+		// are stale when measured against the best tip.
+		stale := bi.StaleTips(2)
+		if !reflect.DeepEqual(stale, want[1:]) {
+			t.Fatalf("StaleTips(%v): got %d stale tips, want %d",
+				perm, len(stale), len(want)-1)
+		}
+	}
+}
+
+// BenchmarkLookupNodeParallel measures the throughput of concurrent LookupNode
+// calls, both alone and while another goroutine keeps adding blocks.
+func BenchmarkLookupNodeParallel(b *testing.B) {
+	const numNodes = 1 << 14
+	for _, withWriter := range []bool{false, true} {
+		b.Run(fmt.Sprintf("writer=%v", withWriter), func(b *testing.B) {
+			bi := newBlockIndex(nil, nil)
+			hashes := make([]hash.Hash, numNodes)
+			for i := range hashes {
+				node := testBlockNode(uint64(i))
+				bi.AddNode(node)
+				hashes[i] = node.hash
+			}
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			if withWriter {
+				go func() {
+					defer close(done)
+					for id := uint64(numNodes); ; id++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						bi.AddNode(testBlockNode(id))
+						time.Sleep(10 * time.Microsecond)
+					}
+				}()
+			} else {
+				close(done)
+			}
+
+			var next uint32
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := atomic.AddUint32(&next, 7919)
+				for pb.Next() {
+					i++
+					if bi.LookupNode(&hashes[i%numNodes]) == nil {
+						b.Fatal("LookupNode: missing node")
+					}
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
@@ -50,6 +50,14 @@ func (b *BlockChain) LatestBlockLocator() (BlockLocator, error) {
 	return locator, nil
 }
 
+// DAGBlockLocator returns a block locator covering every branch of the DAG,
+// starting from its current tips.  See blockIndex.DAGLocator for details.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DAGBlockLocator() BlockLocator {
+	return b.index.DAGLocator(b.bd.GetTips())
+}
+
 // locateBlocks returns the hashes of the blocks after the first known block in
 // the locator until the provided stop hash is nearby, or up to the provided
 // max number of block hashes.
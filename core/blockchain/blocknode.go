@@ -109,6 +109,19 @@ type blockNode struct {
 
 	// dag block id
 	dagID uint
+
+	// prunedParents links the node to its parents by DAG block ID once
+	// PruneBelow has released the parent nodes, in which case parents is
+	// nil.
+	prunedParents *prunedParents
+}
+
+// prunedParents holds the DAG block IDs of the parents of a node along with
+// the parent root of its header, which can no longer be computed from the
+// parent nodes once they have been released.
+type prunedParents struct {
+	ids  []uint
+	root hash.Hash
 }
 
 // newBlockNode returns a new block node for the given block header and parent
@@ -154,13 +167,14 @@ func initBlockNode(node *blockNode, blockHeader *types.BlockHeader, parents []*b
 func (node *blockNode) Header() types.BlockHeader {
 	// No lock is needed because all accessed fields are immutable.
 	var parentRoot hash.Hash
-	if node.parents != nil {
+	if node.prunedParents != nil {
+		parentRoot = node.prunedParents.root
+	} else if node.parents != nil {
 		parents := []*hash.Hash{}
 		for _, v := range node.parents {
 			parents = append(parents, v.GetHash())
 		}
-		paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
-		parentRoot = *paMerkles[len(paMerkles)-1]
+		parentRoot = *merkle.CalcParentsRoot(parents)
 	}
 	return types.BlockHeader{
 		Version:    node.blockVersion,
@@ -222,6 +236,9 @@ func (node *blockNode) CalcWorkSum(mbn *blockNode) {
 
 // Include all parents for set
 func (node *blockNode) GetParents() []uint {
+	if node.prunedParents != nil {
+		return append([]uint(nil), node.prunedParents.ids...)
+	}
 	if node.parents == nil || len(node.parents) == 0 {
 		return nil
 	}
@@ -232,6 +249,28 @@ func (node *blockNode) GetParents() []uint {
 	return result
 }
 
+// hasParents returns whether the node has any parents, including parents that
+// are only linked by ID.  Only the genesis block has none.
+func (node *blockNode) hasParents() bool {
+	return len(node.parents) > 0 || node.prunedParents != nil
+}
+
+// detachParents replaces the parent nodes of the node with their DAG block IDs
+// so PruneBelow can release them.
+//
+// This function MUST be called with the block index lock held (for writes).
+func (node *blockNode) detachParents() {
+	if len(node.parents) == 0 {
+		return
+	}
+	header := node.Header()
+	node.prunedParents = &prunedParents{
+		ids:  node.GetParents(),
+		root: header.ParentRoot,
+	}
+	node.parents = nil
+}
+
 func (node *blockNode) SetOrder(o uint64) {
 	node.order = o
 }
@@ -270,10 +309,12 @@ func (node *blockNode) Clone() *blockNode {
 	newNode.workSum = node.workSum
 	newNode.dirty = node.dirty
 	newNode.dagID = node.dagID
+	newNode.prunedParents = node.prunedParents
 	return newNode
 }
 
-//return parent that position is rather forward
+//return parent that position is rather forward, or nil when the parents are
+//only linked by ID
 func (node *blockNode) GetForwardParent() *blockNode {
 	if node.parents == nil || len(node.parents) <= 0 {
 		return nil
@@ -287,7 +328,8 @@ func (node *blockNode) GetForwardParent() *blockNode {
 	return result
 }
 
-//return parent that position is rather back
+//return parent that position is rather back, or nil when the parents are only
+//linked by ID
 func (node *blockNode) GetBackParent() *blockNode {
 	if node.parents == nil || len(node.parents) <= 0 {
 		return nil
@@ -233,7 +233,7 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 		}
 		// Get the previous node while staying at the genesis block as
 		// needed.
-		if oldNode.parents != nil {
+		if oldNode.hasParents() {
 			oldBlock := b.bd.GetBlockById(oldNode.GetID())
 			oldMainParent := b.bd.GetBlockById(oldBlock.GetMainParent())
 			if oldMainParent != nil {
@@ -310,7 +310,7 @@ func (b *BlockChain) calcCurrentPowCount(curNode *blockNode, nodesToTraverse int
 		if oldNode.order == 0 {
 			currentPowBlockCount--
 		}
-		if oldNode.parents != nil {
+		if oldNode.hasParents() {
 			oldBlock := b.bd.GetBlockById(oldNode.GetID())
 			oldMainParent := b.bd.GetBlockById(oldBlock.GetMainParent())
 			if oldMainParent != nil {
@@ -361,7 +361,7 @@ func (b *BlockChain) getDistanceFromLastAdjustment(curNode *blockNode, powType p
 			count >= needAjustCount {
 			return needAjustCount * b.params.WorkDiffWindows
 		}
-		if curNode.parents == nil {
+		if !curNode.hasParents() {
 			return count
 		}
 
@@ -414,7 +414,7 @@ func (b *BlockChain) getPowTypeNode(curNode *blockNode, powType pow.PowType) *bl
 			return curNode
 		}
 
-		if curNode.parents == nil {
+		if !curNode.hasParents() {
 			return &blockNode{
 				pow: pow.GetInstance(powType, 0, []byte{}),
 			}
@@ -442,7 +442,7 @@ func (b *BlockChain) GetCurrentPowDiff(curNode blockNode, powType pow.PowType) *
 			return pow.CompactToBig(curNode.Header().Difficulty)
 		}
 
-		if curNode.parents == nil {
+		if !curNode.hasParents() {
 			return safeBigDiff
 		}
 
@@ -257,6 +257,12 @@ func serializeSpendJournalEntry(stxos []SpentTxOut) ([]byte, error) {
 	return serialized, nil
 }
 
+// DBFetchSpendJournalEntry fetches the spend journal entry for the passed
+// block using an existing database transaction.
+func DBFetchSpendJournalEntry(dbTx database.Tx, block *types.SerializedBlock) ([]SpentTxOut, error) {
+	return dbFetchSpendJournalEntry(dbTx, block)
+}
+
 // dbFetchSpendJournalEntry fetches the spend journal entry for the passed
 // block and deserializes it into a slice of spent txout entries.  The provided
 // view MUST have the utxos referenced by all of the transactions available for
@@ -97,8 +97,7 @@ func (b *BlockChain) checkBlockSanity(block *types.SerializedBlock, timeSource M
 	// checks.  The tree here and checks the merkle root
 	// after the following checks, but there is no reason not to check the
 	// merkle root matches here.
-	paMerkles := merkle.BuildParentsMerkleTreeStore(msgBlock.Parents)
-	paMerkleRoot := paMerkles[len(paMerkles)-1]
+	paMerkleRoot := merkle.CalcParentsRoot(msgBlock.Parents)
 	if !header.ParentRoot.IsEqual(paMerkleRoot) {
 		str := fmt.Sprintf("block parents merkle root is invalid - block "+
 			"header indicates %v, but calculated value is %v",
@@ -174,13 +173,9 @@ func (b *BlockChain) checkBlockSanity(block *types.SerializedBlock, timeSource M
 	// checks.  Bitcoind builds the tree here and checks the merkle root
 	// after the following checks, but there is no reason not to check the
 	// merkle root matches here.
-	merkles := merkle.BuildMerkleTreeStore(block.Transactions(), false)
-	calculatedMerkleRoot := merkles[len(merkles)-1]
-	if !header.TxRoot.IsEqual(calculatedMerkleRoot) {
-		str := fmt.Sprintf("block merkle root is invalid - block "+
-			"header indicates %v, but calculated value is %v",
-			header.TxRoot, calculatedMerkleRoot)
-		return ruleError(ErrBadMerkleRoot, str)
+	err = merkle.ValidateMerkleRoot(block.Transactions(), &header.TxRoot, false)
+	if err != nil {
+		return ruleError(ErrBadMerkleRoot, "block "+err.Error())
 	}
 
 	// Check for duplicate transactions.  This check will be fairly quick
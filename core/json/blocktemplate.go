@@ -27,6 +27,10 @@ type TemplateRequest struct {
 	Mode         string   `json:"mode,omitempty"`
 	Capabilities []string `json:"capabilities,omitempty"`
 
+	// PowType is the proof of work algorithm the template is requested
+	// for.  The returned bits and target are those for this algorithm.
+	PowType uint8 `json:"powtype"`
+
 	// Optional long polling.
 	LongPollID string `json:"longpollid,omitempty"`
 
@@ -65,8 +69,27 @@ type GetBlockTemplateResultPt struct {
 
 // GetBlockTemplateResultAux models the coinbaseaux field of the
 // getblocktemplate command.
+//
+// When the template includes its coinbase, ExtraNonceOffset and ExtraNonceSize
+// give the byte range of the coinbase signature script a miner may overwrite
+// with its own extra nonce, so a pool can hand out unique work to many miners.
+// After changing the extra nonce, the miner must recompute the witness
+// commitment, which is stored in the previous outpoint hash of the coinbase
+// input and covers the coinbase signature script, and then the merkle root of
+// the block header.
 type GetBlockTemplateResultAux struct {
-	Flags string `json:"flags"`
+	Flags            string `json:"flags"`
+	ExtraNonceOffset int    `json:"extranonceoffset,omitempty"`
+	ExtraNonceSize   int    `json:"extranoncesize,omitempty"`
+}
+
+// GetBlockTemplateResultOutput models an output a miner constructing its own
+// coinbase must include, such as the block tax, as part of the
+// coinbaserequiredoutputs field of the getblocktemplate command.
+type GetBlockTemplateResultOutput struct {
+	Index    int    `json:"index"`
+	Amount   uint64 `json:"amount"`
+	PkScript string `json:"pkscript"`
 }
 
 // GetBlockTemplateResult models the data returned from the getblocktemplate
@@ -82,6 +105,7 @@ type GetBlockTemplateResult struct {
 	SizeLimit     int64                      `json:"sizelimit,omitempty"`
 	WeightLimit   int64                      `json:"weightlimit,omitempty"`
 	Parents       []GetBlockTemplateResultPt `json:"parents"`
+	ParentHashes  []string                   `json:"parenthashes"`
 	Transactions  []GetBlockTemplateResultTx `json:"transactions"`
 	Version       uint32                     `json:"version"`
 	CoinbaseAux   *GetBlockTemplateResultAux `json:"coinbaseaux,omitempty"`
@@ -89,6 +113,12 @@ type GetBlockTemplateResult struct {
 	CoinbaseValue *uint64                    `json:"coinbasevalue,omitempty"`
 	WorkID        string                     `json:"workid,omitempty"`
 
+	// CoinbaseValue is only the part of the block reward spendable by the
+	// miner.  CoinbaseSubsidy is the whole reward and the required outputs
+	// are the remainder the coinbase must pay, at their given indexes.
+	CoinbaseSubsidy         uint64                         `json:"coinbasesubsidy,omitempty"`
+	CoinbaseRequiredOutputs []GetBlockTemplateResultOutput `json:"coinbaserequiredoutputs,omitempty"`
+
 	// Witness commitment defined in BIP 0141.
 	DefaultWitnessCommitment string `json:"default_witness_commitment,omitempty"`
 
@@ -100,6 +130,12 @@ type GetBlockTemplateResult struct {
 	// Basic pool extension from BIP 0023.
 	Expires          int64            `json:"expires,omitempty"`
 	PowDiffReference PowDiffReference `json:"pow_diff_reference"`
+
+	// Difficulty for the pow type the template was requested for.
+	PowType uint8  `json:"pow_type"`
+	Bits    string `json:"bits"`
+	Target  string `json:"target"`
+
 	// Mutations from BIP 0023.
 	MaxTime    int64    `json:"maxtime,omitempty"`
 	MinTime    int64    `json:"mintime,omitempty"`
@@ -110,3 +146,81 @@ type GetBlockTemplateResult struct {
 	Capabilities  []string `json:"capabilities,omitempty"`
 	RejectReasion string   `json:"reject-reason,omitempty"`
 }
+
+// SubmitBlockResult models the data returned from the submitblock command.
+// RejectReason is only set when the block was not accepted and holds one of
+// the BIP 0022 style reject categories such as "duplicate" or "bad-pow".
+type SubmitBlockResult struct {
+	BlockHash    string `json:"blockhash"`
+	Accepted     bool   `json:"accepted"`
+	RejectReason string `json:"reject-reason,omitempty"`
+	Message      string `json:"message"`
+	Height       uint64 `json:"height,omitempty"`
+	Order        string `json:"order,omitempty"`
+	Amount       uint64 `json:"amount,omitempty"`
+
+	// HeaderMerkleRoot and CalcMerkleRoot are only set when the block was
+	// rejected for a bad merkle root.
+	HeaderMerkleRoot string `json:"headermerkleroot,omitempty"`
+	CalcMerkleRoot   string `json:"calcmerkleroot,omitempty"`
+}
+
+// SubmitBlockJobResult models the data returned from the getsubmitresult
+// command for a block previously handed to submitblockasync.
+type SubmitBlockJobResult struct {
+	JobID  string      `json:"jobid"`
+	Status string      `json:"status"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// NextBlockContext models the data returned from the getnextblockcontext
+// command, which describes the block a miner would build next without
+// creating a full block template.
+type NextBlockContext struct {
+	Parents    []string `json:"parents"`
+	NextHeight uint64   `json:"nextheight"`
+	NextOrder  uint32   `json:"nextorder"`
+	PowDiffs   PowDiff  `json:"powdiffs"`
+}
+
+// GetWorkResult models the data returned from the getwork command.  Data is
+// the hex-encoded serialized block header to solve and Target the hex-encoded
+// 256-bit target the solution must satisfy.
+type GetWorkResult struct {
+	Data    string `json:"data"`
+	Target  string `json:"target"`
+	PowType uint8  `json:"powtype"`
+}
+
+// BlockFoundNtfn is the notification sent to blockFound subscribers whenever a
+// block mined or submitted through this node is accepted.
+type BlockFoundNtfn struct {
+	Hash    string `json:"hash"`
+	Height  uint64 `json:"height"`
+	PowType string `json:"powtype"`
+}
+
+// MiningStatsResult models the data returned from the getMiningStats command.
+type MiningStatsResult struct {
+	Mining          bool               `json:"mining"`
+	HashesPerSecond float64            `json:"hashespersec"`
+	HashrateByPow   map[string]float64 `json:"hashratebypow,omitempty"`
+}
+
+// TemplateCheckResult is the outcome of a single check run by the
+// validateTemplate command.
+type TemplateCheckResult struct {
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TemplateValidationResult models the data returned from the validateTemplate
+// command.  Valid is only set when every check passed.
+type TemplateValidationResult struct {
+	Valid             bool                `json:"valid"`
+	MerkleRoot        TemplateCheckResult `json:"merkleroot"`
+	WitnessCommitment TemplateCheckResult `json:"witnesscommitment"`
+	CoinbaseHeight    TemplateCheckResult `json:"coinbaseheight"`
+	TxOrder           TemplateCheckResult `json:"txorder"`
+}
@@ -1,5 +1,7 @@
 package json
 
+import "strings"
+
 // for pow diff
 type PowDiff struct {
 	Blake2bdDiff float64 `json:"blake2bd_diff"`
@@ -15,6 +17,8 @@ type InfoNodeResult struct {
 	ProtocolVersion  int32               `json:"protocolversion"`
 	TotalSubsidy     uint64              `json:"totalsubsidy"`
 	GraphState       GetGraphStateResult `json:"graphstate"`
+	BlockTotal       uint64              `json:"blocktotal"`
+	TipCount         int                 `json:"tipcount"`
 	TimeOffset       int64               `json:"timeoffset"`
 	Connections      int32               `json:"connections"`
 	PowDiff          PowDiff             `json:"pow_diff"`
@@ -24,30 +28,40 @@ type InfoNodeResult struct {
 	CoinbaseMaturity int32               `json:"coinbasematurity"`
 	Errors           string              `json:"errors"`
 	Modules          []string            `json:"modules"`
+	Indexes          []string            `json:"indexes"`
 }
 
 // GetPeerInfoResult models the data returned from the getpeerinfo command.
+// BestHash and BestOrder are the main chain tip of the graph state the peer
+// last announced and its order, so peers can be compared at a glance.  The main
+// chain tip is used rather than the tip with the highest order because a graph
+// state only carries the order of its main chain tip, and the other tips may be
+// unknown locally, so their orders can't be compared.
 type GetPeerInfoResult struct {
-	UUID       string              `json:"uuid"`
-	ID         int32               `json:"id"`
-	Addr       string              `json:"addr"`
-	AddrLocal  string              `json:"addrlocal,omitempty"`
-	Services   string              `json:"services"`
-	RelayTxes  bool                `json:"relaytxes"`
-	LastSend   int64               `json:"lastsend"`
-	LastRecv   int64               `json:"lastrecv"`
-	BytesSent  uint64              `json:"bytessent"`
-	BytesRecv  uint64              `json:"bytesrecv"`
-	ConnTime   int64               `json:"conntime"`
-	TimeOffset int64               `json:"timeoffset"`
-	PingTime   float64             `json:"pingtime"`
-	PingWait   float64             `json:"pingwait,omitempty"`
-	Version    uint32              `json:"version"`
-	SubVer     string              `json:"subver"`
-	Inbound    bool                `json:"inbound"`
-	BanScore   int32               `json:"banscore"`
-	SyncNode   bool                `json:"syncnode"`
-	GraphState GetGraphStateResult `json:"graphstate"`
+	UUID         string              `json:"uuid"`
+	ID           int32               `json:"id"`
+	Addr         string              `json:"addr"`
+	AddrLocal    string              `json:"addrlocal,omitempty"`
+	Services     string              `json:"services"`
+	ServicesList []string            `json:"serviceslist"`
+	RelayTxes    bool                `json:"relaytxes"`
+	LastSend     int64               `json:"lastsend"`
+	LastRecv     int64               `json:"lastrecv"`
+	BytesSent    uint64              `json:"bytessent"`
+	BytesRecv    uint64              `json:"bytesrecv"`
+	ConnTime     int64               `json:"conntime"`
+	TimeOffset   int64               `json:"timeoffset,omitempty"`
+	PingTime     float64             `json:"pingtime"`
+	PingWait     float64             `json:"pingwait,omitempty"`
+	MinPing      float64             `json:"minping,omitempty"`
+	Version      uint32              `json:"version"`
+	SubVer       string              `json:"subver"`
+	Inbound      bool                `json:"inbound"`
+	BanScore     int32               `json:"banscore"`
+	SyncNode     bool                `json:"syncnode"`
+	GraphState   GetGraphStateResult `json:"graphstate"`
+	BestHash     string              `json:"besthash,omitempty"`
+	BestOrder    uint32              `json:"bestorder"`
 }
 
 // GetGraphStateResult data
@@ -58,7 +72,118 @@ type GetGraphStateResult struct {
 	Layer      uint32   `json:"layer"`
 }
 
+// graphStateTipHash returns the hash of a tip as listed by
+// GetGraphStateResult, where the main chain tip carries a " main" suffix.
+func graphStateTipHash(tip string) string {
+	if i := strings.IndexByte(tip, ' '); i >= 0 {
+		return tip[:i]
+	}
+	return tip
+}
+
+// GraphStateDelta returns the hashes of the tips the remote graph state has
+// that the local one lacks, in the order the remote lists them.  The returned
+// flag reports whether the two DAGs have diverged, that is each one has tips
+// the other lacks, so neither is a superset of the other.  A tip the local
+// node lacks may still be a block it knows that is no longer one of its tips,
+// so callers should skip blocks they already have before requesting them.
+func GraphStateDelta(local, remote *GetGraphStateResult) (missing []string, diverged bool) {
+	localTips := make(map[string]struct{}, len(local.Tips))
+	for _, tip := range local.Tips {
+		localTips[graphStateTipHash(tip)] = struct{}{}
+	}
+	remoteTips := make(map[string]struct{}, len(remote.Tips))
+	for _, tip := range remote.Tips {
+		h := graphStateTipHash(tip)
+		remoteTips[h] = struct{}{}
+		if _, ok := localTips[h]; !ok {
+			missing = append(missing, h)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, false
+	}
+	for h := range localTips {
+		if _, ok := remoteTips[h]; !ok {
+			return missing, true
+		}
+	}
+	return missing, false
+}
+
+// GetSyncProgressResult models the data returned from the getsyncprogress
+// command.  Best is the graph state of the best known peer, or the local one
+// when no peer is ahead, and Progress is a fraction between 0 and 1.
+type GetSyncProgressResult struct {
+	Current  GetGraphStateResult `json:"current"`
+	Best     GetGraphStateResult `json:"best"`
+	Progress float64             `json:"progress"`
+}
+
+// SyncProgress returns the fraction of the best known main order the local
+// graph state has reached, capped at 1.  A node whose main order is not behind
+// the best one is fully synced.
+func SyncProgress(current, best *GetGraphStateResult) float64 {
+	if current.MainOrder >= best.MainOrder {
+		return 1
+	}
+	return float64(current.MainOrder) / float64(best.MainOrder)
+}
+
+// GetChainTipsResult models the data of a single tip returned from the
+// getchaintips command.  Status is one of active, valid-fork or invalid.
+type GetChainTipsResult struct {
+	Hash      string `json:"hash"`
+	Height    uint64 `json:"height"`
+	Layer     uint32 `json:"layer"`
+	Order     uint32 `json:"order"`
+	Status    string `json:"status"`
+	BranchLen uint32 `json:"branchlen"`
+}
+
+// GetBanlistResult models a single banned host returned by the banlist
+// command.  BannedUntil is the ban expiry as a unix timestamp.
 type GetBanlistResult struct {
-	Host   string `json:"host"`
-	Expire string `json:"expire"`
+	Host        string `json:"host"`
+	Address     string `json:"address"`
+	Expire      string `json:"expire"`
+	BannedUntil int64  `json:"banneduntil"`
+	Reason      string `json:"reason,omitempty"`
 }
+
+// GetMempoolInfoResult models the data returned from the getmempoolinfo
+// command.
+type GetMempoolInfoResult struct {
+	Size          int     `json:"size"`
+	Bytes         uint64  `json:"bytes"`
+	Usage         uint64  `json:"usage"`
+	MaxMempool    uint64  `json:"maxmempool"`
+	MempoolMinFee float64 `json:"mempoolminfee"`
+}
+
+// SoftForkDescription describes the current state of a bit-based consensus
+// deployment as defined by params.ConsensusDeployment.  No command returns it
+// yet; it is reserved for reporting deployments once their threshold state is
+// tracked by the chain.
+type SoftForkDescription struct {
+	Status     string              `json:"status"`
+	Bit        uint8               `json:"bit"`
+	StartTime  int64               `json:"starttime"`
+	Timeout    int64               `json:"timeout"`
+	Statistics *SoftForkStatistics `json:"statistics,omitempty"`
+}
+
+// SoftForkStatistics reports the signaling progress of a deployment within the
+// current retarget window.  It is only present while the deployment is still
+// being voted on.
+type SoftForkStatistics struct {
+	Period    uint32 `json:"period"`
+	Threshold uint32 `json:"threshold"`
+	Elapsed   uint32 `json:"elapsed"`
+	Count     uint32 `json:"count"`
+	Possible  bool   `json:"possible"`
+}
+
+// GetNetworkHashPSResult models the data returned from the getnetworkhashps
+// command.  It maps each pow type name to its estimated hashes per second.
+type GetNetworkHashPSResult map[string]float64
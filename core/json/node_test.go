@@ -0,0 +1,235 @@
+package json
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestNodeResultsRoundTrip ensures every node result type survives a
+// marshal/unmarshal cycle unchanged.
+func TestNodeResultsRoundTrip(t *testing.T) {
+	graphState := GetGraphStateResult{
+		Tips:       []string{"0c4d9f6a main", "7a1b2c3d"},
+		MainOrder:  120,
+		MainHeight: 100,
+		Layer:      101,
+	}
+	tests := []struct {
+		name string
+		in   interface{}
+		out  interface{}
+	}{
+		{
+			name: "InfoNodeResult",
+			in: &InfoNodeResult{
+				UUID:            "a9b8c7d6",
+				Version:         80000,
+				BuildVersion:    "0.8.0",
+				ProtocolVersion: 13,
+				TotalSubsidy:    5000,
+				GraphState:      graphState,
+				BlockTotal:      121,
+				TipCount:        2,
+				TimeOffset:      -1,
+				Connections:     8,
+				PowDiff: PowDiff{
+					Blake2bdDiff: 1.5,
+					CuckarooDiff: 2.5,
+					CuckatooDiff: 3.5,
+				},
+				TestNet:          true,
+				Confirmations:    10,
+				CoinbaseMaturity: 16,
+				Errors:           "",
+				Modules:          []string{"qitmeer", "miner"},
+			},
+			out: &InfoNodeResult{},
+		},
+		{
+			name: "GetPeerInfoResult",
+			in: &GetPeerInfoResult{
+				UUID:         "f1e2d3c4",
+				ID:           3,
+				Addr:         "127.0.0.1:18130",
+				AddrLocal:    "127.0.0.1:53210",
+				Services:     "00000005",
+				ServicesList: []string{"SFNodeNetwork", "SFNodeBloom"},
+				RelayTxes:    true,
+				LastSend:     1570000000,
+				LastRecv:     1570000001,
+				BytesSent:    1024,
+				BytesRecv:    2048,
+				ConnTime:     1569999000,
+				TimeOffset:   2,
+				PingTime:     0.025,
+				PingWait:     0.5,
+				MinPing:      0.012,
+				Version:      13,
+				SubVer:       "/qitmeer:0.8.0/",
+				Inbound:      true,
+				BanScore:     0,
+				SyncNode:     true,
+				GraphState:   graphState,
+				BestHash:     "aa",
+				BestOrder:    graphState.MainOrder,
+			},
+			out: &GetPeerInfoResult{},
+		},
+		{
+			name: "GetPeerInfoResult without optional fields",
+			in: &GetPeerInfoResult{
+				ID:           4,
+				Addr:         "10.0.0.2:18130",
+				Services:     "00000000",
+				ServicesList: []string{},
+				GraphState:   GetGraphStateResult{Tips: []string{}},
+			},
+			out: &GetPeerInfoResult{},
+		},
+		{
+			name: "GetGraphStateResult",
+			in:   &graphState,
+			out:  &GetGraphStateResult{},
+		},
+		{
+			name: "GetChainTipsResult",
+			in: &GetChainTipsResult{
+				Hash:      "7a1b2c3d",
+				Height:    99,
+				Layer:     100,
+				Order:     118,
+				Status:    "valid-fork",
+				BranchLen: 1,
+			},
+			out: &GetChainTipsResult{},
+		},
+		{
+			name: "GetBanlistResult",
+			in: &GetBanlistResult{
+				Host:        "10.0.0.3",
+				Address:     "10.0.0.3:18130",
+				Expire:      "2019-10-01 00:00:00",
+				BannedUntil: 1569888000,
+				Reason:      "invalid block",
+			},
+			out: &GetBanlistResult{},
+		},
+		{
+			name: "GetMempoolInfoResult",
+			in: &GetMempoolInfoResult{
+				Size:          3,
+				Bytes:         750,
+				Usage:         900,
+				MaxMempool:    0,
+				MempoolMinFee: 0.0001,
+			},
+			out: &GetMempoolInfoResult{},
+		},
+		{
+			name: "SoftForkDescription",
+			in: &SoftForkDescription{
+				Status:    "started",
+				Bit:       1,
+				StartTime: 1569999000,
+				Timeout:   1600000000,
+				Statistics: &SoftForkStatistics{
+					Period:    2016,
+					Threshold: 1512,
+					Elapsed:   300,
+					Count:     250,
+					Possible:  true,
+				},
+			},
+			out: &SoftForkDescription{},
+		},
+		{
+			name: "GetNetworkHashPSResult",
+			in: &GetNetworkHashPSResult{
+				"blake2bd": 1200.5,
+				"cuckaroo": 0,
+			},
+			out: &GetNetworkHashPSResult{},
+		},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(test.in)
+		if err != nil {
+			t.Errorf("%s: unexpected marshal error: %v", test.name, err)
+			continue
+		}
+		if err := json.Unmarshal(data, test.out); err != nil {
+			t.Errorf("%s: unexpected unmarshal error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.in, test.out) {
+			t.Errorf("%s: mismatched round trip - got %+v, want %+v",
+				test.name, test.out, test.in)
+		}
+	}
+}
+
+// TestPeerInfoOmitEmpty ensures the per-peer fields which are only meaningful
+// once a peer has exchanged the relevant messages are left out when unset.
+func TestPeerInfoOmitEmpty(t *testing.T) {
+	data, err := json.Marshal(&GetPeerInfoResult{})
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+	for _, field := range []string{"addrlocal", "timeoffset", "pingwait", "minping", "besthash"} {
+		if strings.Contains(string(data), "\""+field+"\"") {
+			t.Errorf("field %q should be omitted when empty: %s", field, data)
+		}
+	}
+}
+
+// TestGraphStateDelta ensures the tips missing locally are reported and DAG
+// divergence is detected.
+func TestGraphStateDelta(t *testing.T) {
+	local := &GetGraphStateResult{Tips: []string{"aa main", "bb"}}
+	tests := []struct {
+		name     string
+		remote   []string
+		missing  []string
+		diverged bool
+	}{
+		{"same", []string{"bb main", "aa"}, nil, false},
+		{"behind", []string{"aa main"}, nil, false},
+		{"ahead", []string{"cc main", "aa", "bb"}, []string{"cc"}, false},
+		{"diverged", []string{"cc main", "aa"}, []string{"cc"}, true},
+	}
+	for _, test := range tests {
+		missing, diverged := GraphStateDelta(local,
+			&GetGraphStateResult{Tips: test.remote})
+		if !reflect.DeepEqual(missing, test.missing) ||
+			diverged != test.diverged {
+			t.Errorf("%s: got (%v, %v), want (%v, %v)", test.name,
+				missing, diverged, test.missing, test.diverged)
+		}
+	}
+}
+
+// TestSyncProgress ensures the progress is the fraction of the best main order
+// reached and is capped at 1.
+func TestSyncProgress(t *testing.T) {
+	tests := []struct {
+		current, best uint32
+		want          float64
+	}{
+		{0, 0, 1},
+		{0, 200, 0},
+		{50, 200, 0.25},
+		{200, 200, 1},
+		{300, 200, 1},
+	}
+	for _, test := range tests {
+		got := SyncProgress(&GetGraphStateResult{MainOrder: test.current},
+			&GetGraphStateResult{MainOrder: test.best})
+		if got != test.want {
+			t.Errorf("SyncProgress(%d, %d): got %v, want %v",
+				test.current, test.best, got, test.want)
+		}
+	}
+}
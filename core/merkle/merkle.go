@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/types"
-	"math"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 //TODO refactoing the merkle root calculation to support abstract merkle node
@@ -44,32 +47,41 @@ func CalcMerkleRoot(nodes []hash.Hash) (root hash.Hash, err error) {
 // Since this function uses nodes that are pointers to the hashes, empty nodes
 // will be nil.
 func BuildMerkleTreeStore(transactions []*types.Tx, witness bool) []*hash.Hash {
+	return BuildMerkleTreeStoreInto(nil, transactions, witness)
+}
+
+// BuildMerkleTreeStoreInto is like BuildMerkleTreeStore, but builds the tree in
+// the passed buffer, which is only grown when it is too small.  The hashes the
+// non-nil nodes of the buffer point to are overwritten and reused, so building
+// trees of similar sizes with a buffer returned by a previous call allocates
+// nothing but the transaction hashes.  The returned tree and the hashes it
+// points to are therefore only valid until the buffer is reused.  A nil buffer
+// builds the tree in new storage.
+func BuildMerkleTreeStoreInto(buf []*hash.Hash, transactions []*types.Tx, witness bool) []*hash.Hash {
 	// If there's an empty stake tree, return totally zeroed out merkle tree root
 	// only.
 	if len(transactions) == 0 {
-		merkles := make([]*hash.Hash, 1)
-		merkles[0] = &hash.Hash{}
+		merkles := growMerkleBuf(buf, 1)
+		var spare []hash.Hash
+		*merkleNode(merkles, 0, &spare) = hash.Hash{}
 		return merkles
 	}
 
 	// Calculate how many entries are required to hold the binary merkle
-	// tree as a linear array and create an array of that size.
+	// tree as a linear array and resize the buffer to that size.  Nodes
+	// without a hash to reuse share a single backing array rather than
+	// being allocated one by one.
 	nextPoT := nextPowerOfTwo(len(transactions))
 	arraySize := nextPoT*2 - 1
-	merkles := make([]*hash.Hash, arraySize)
+	merkles := growMerkleBuf(buf, arraySize)
+	var spare []hash.Hash
 
 	// Create the base transaction hashes and populate the array with them.
 	for i, tx := range transactions {
-		switch {
-		case witness && i == 0:
-			merkles[i] = &hash.ZeroHash
-		case witness:
-			wSha := tx.Tx.TxHashFull()
-			merkles[i] = &wSha
-		default:
-			txH := tx.Tx.TxHash()
-			merkles[i] = &txH
-		}
+		*merkleNode(merkles, i, &spare) = txLeafHash(tx, i, witness)
+	}
+	for i := len(transactions); i < nextPoT; i++ {
+		merkles[i] = nil
 	}
 
 	// Start the array offset after the last transaction and adjusted to the
@@ -84,14 +96,14 @@ func BuildMerkleTreeStore(transactions []*types.Tx, witness bool) []*hash.Hash {
 		// When there is no right child, the parent is generated by
 		// hashing the concatenation of the left child with itself.
 		case merkles[i+1] == nil:
-			newHash := hashMerkleBranches(merkles[i], merkles[i])
-			merkles[offset] = newHash
+			*merkleNode(merkles, offset, &spare) =
+				hashMerkleBranchesH(merkles[i], merkles[i])
 
 		// The normal case sets the parent node to the hash of the
 		// concatentation of the left and right children.
 		default:
-			newHash := hashMerkleBranches(merkles[i], merkles[i+1])
-			merkles[offset] = newHash
+			*merkleNode(merkles, offset, &spare) =
+				hashMerkleBranchesH(merkles[i], merkles[i+1])
 		}
 		offset++
 	}
@@ -99,6 +111,267 @@ func BuildMerkleTreeStore(transactions []*types.Tx, witness bool) []*hash.Hash {
 	return merkles
 }
 
+// growMerkleBuf returns the passed buffer resized to the passed size, keeping
+// the nodes it already holds.  A larger buffer is only allocated when the
+// capacity of the passed one is too small.
+func growMerkleBuf(buf []*hash.Hash, size int) []*hash.Hash {
+	if cap(buf) < size {
+		grown := make([]*hash.Hash, size)
+		copy(grown, buf[:cap(buf)])
+		return grown
+	}
+	return buf[:size]
+}
+
+// merkleNode returns the hash the node at the passed index of a merkle tree
+// points to so it can be overwritten.  A nil node is pointed to the next hash
+// of the passed spare storage, which is allocated for the rest of the tree when
+// it runs out.
+func merkleNode(merkles []*hash.Hash, index int, spare *[]hash.Hash) *hash.Hash {
+	if merkles[index] == nil {
+		if len(*spare) == 0 {
+			*spare = make([]hash.Hash, len(merkles)-index)
+		}
+		merkles[index] = &(*spare)[0]
+		*spare = (*spare)[1:]
+	}
+	return merkles[index]
+}
+
+// UpdateMerkleRoot replaces the leaf at the passed index of a merkle tree as
+// returned by BuildMerkleTreeStore and rehashes only the nodes on its path to
+// the root, which it returns.  This makes changing a single transaction, such
+// as the coinbase when rolling an extra nonce, logarithmic in the number of
+// transactions.  The tree is updated in place by storing new hashes in the
+// affected nodes, the hashes they pointed to are not modified.  Nil is
+// returned, and the tree is left unchanged, when the index does not refer to
+// a transaction of the tree.
+func UpdateMerkleRoot(tree []*hash.Hash, leafIndex int, newLeaf *hash.Hash) *hash.Hash {
+	// The tree holds the leaves padded to the next power of two followed
+	// by every level above them, so it always has an odd length.
+	if len(tree)%2 == 0 {
+		return nil
+	}
+	width := (len(tree) + 1) / 2
+	if leafIndex < 0 || leafIndex >= width || tree[leafIndex] == nil {
+		return nil
+	}
+
+	leaf := *newLeaf
+	tree[leafIndex] = &leaf
+	levelStart, index := 0, leafIndex
+	for width > 1 {
+		left := index &^ 1
+		var parent hash.Hash
+		if right := tree[levelStart+left+1]; right != nil {
+			parent = hashMerkleBranchesH(tree[levelStart+left], right)
+		} else {
+			parent = hashMerkleBranchesH(tree[levelStart+left],
+				tree[levelStart+left])
+		}
+		levelStart += width
+		width /= 2
+		index /= 2
+		tree[levelStart+index] = &parent
+	}
+	return tree[len(tree)-1]
+}
+
+// txLeafHash returns the merkle leaf for the transaction at the passed index.
+// The witness tree commits to the full transaction hashes with the coinbase
+// replaced by the zero hash.
+func txLeafHash(tx *types.Tx, index int, witness bool) hash.Hash {
+	switch {
+	case witness && index == 0:
+		return hash.ZeroHash
+	case witness:
+		return tx.Tx.TxHashFull()
+	default:
+		return tx.Tx.TxHash()
+	}
+}
+
+// LeafHashes returns the leaves of the merkle tree of the passed transactions,
+// the hashes BuildMerkleTreeStore places first in its tree.  The leaves of the
+// witness tree are the full transaction hashes with the coinbase replaced by
+// the zero hash.
+func LeafHashes(transactions []*types.Tx, witness bool) []*hash.Hash {
+	leaves := make([]*hash.Hash, len(transactions))
+	for i, tx := range transactions {
+		leaf := txLeafHash(tx, i, witness)
+		leaves[i] = &leaf
+	}
+	return leaves
+}
+
+// smallTreeMaxTxns is the largest number of transactions for which
+// CalcTxMerkleRoot computes the root directly instead of building the tree.
+const smallTreeMaxTxns = 4
+
+// CalcTxMerkleRoot returns the merkle root of the passed transactions, the
+// same root BuildMerkleTreeStore places last in its tree.  Blocks with only a
+// handful of transactions are very common, so for those the root is hashed
+// directly without allocating the tree.
+func CalcTxMerkleRoot(transactions []*types.Tx, witness bool) hash.Hash {
+	if len(transactions) > smallTreeMaxTxns {
+		merkles := BuildMerkleTreeStore(transactions, witness)
+		return *merkles[len(merkles)-1]
+	}
+
+	var leaves [smallTreeMaxTxns]hash.Hash
+	for i, tx := range transactions {
+		leaves[i] = txLeafHash(tx, i, witness)
+	}
+	switch len(transactions) {
+	case 0:
+		return hash.Hash{}
+	case 1:
+		return leaves[0]
+	case 2:
+		return hashMerkleBranchesH(&leaves[0], &leaves[1])
+	case 3:
+		left := hashMerkleBranchesH(&leaves[0], &leaves[1])
+		right := hashMerkleBranchesH(&leaves[2], &leaves[2])
+		return hashMerkleBranchesH(&left, &right)
+	default:
+		left := hashMerkleBranchesH(&leaves[0], &leaves[1])
+		right := hashMerkleBranchesH(&leaves[2], &leaves[3])
+		return hashMerkleBranchesH(&left, &right)
+	}
+}
+
+// ValidateMerkleRoot calculates the merkle root of the passed transactions and
+// returns an error including both roots when it does not match the claimed
+// root, such as the transaction root committed to by a block header.
+func ValidateMerkleRoot(transactions []*types.Tx, claimedRoot *hash.Hash, witness bool) error {
+	calculatedRoot := CalcTxMerkleRoot(transactions, witness)
+	if !claimedRoot.IsEqual(&calculatedRoot) {
+		return fmt.Errorf("merkle root is invalid - claimed %v, but "+
+			"calculated value is %v", claimedRoot, calculatedRoot)
+	}
+	return nil
+}
+
+// ValidateMerkleRootsParallel validates the merkle roots of the passed blocks
+// against the transaction roots of their headers, spreading the blocks across
+// the passed number of workers, or one per CPU when it is not positive.  When
+// any roots are invalid the error for the first such block in the passed
+// order is returned, including the hash of the block.  Blocks after a failure
+// are skipped as soon as it is detected.
+func ValidateMerkleRootsParallel(blocks []*types.SerializedBlock, workers int) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(blocks) {
+		workers = len(blocks)
+	}
+
+	var (
+		next   int64 = -1
+		failed int64 = int64(len(blocks))
+		errs         = make([]error, len(blocks))
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := atomic.AddInt64(&next, 1)
+				if i >= atomic.LoadInt64(&failed) {
+					return
+				}
+				block := blocks[i]
+				err := ValidateMerkleRoot(block.Transactions(),
+					&block.Block().Header.TxRoot, false)
+				if err == nil {
+					continue
+				}
+				errs[i] = fmt.Errorf("block %v: %v", block.Hash(), err)
+
+				// Lower the failure index so blocks after it are
+				// skipped.
+				for {
+					cur := atomic.LoadInt64(&failed)
+					if i >= cur || atomic.CompareAndSwapInt64(&failed, cur, i) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed < int64(len(blocks)) {
+		return errs[failed]
+	}
+	return nil
+}
+
+// CheckTxOrder ensures the passed transactions are in the canonical order of a
+// block: the coinbase comes first, no other transaction is a coinbase and every
+// transaction comes after the transactions of the block whose outputs it
+// spends.  It is meant for checking block templates before their merkle root is
+// computed, since a root over out of order transactions is still a valid root.
+// The consensus rules already enforce the same order during block validation.
+func CheckTxOrder(transactions []*types.Tx) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+	if !transactions[0].Tx.IsCoinBase() {
+		return fmt.Errorf("first transaction is not a coinbase")
+	}
+
+	indexes := make(map[hash.Hash]int, len(transactions))
+	for i, tx := range transactions {
+		indexes[*tx.Hash()] = i
+	}
+	for i, tx := range transactions[1:] {
+		i++
+		if tx.Tx.IsCoinBase() {
+			return fmt.Errorf("transaction %v at index %d is a second "+
+				"coinbase", tx.Hash(), i)
+		}
+		for _, txIn := range tx.Tx.TxIn {
+			origin := &txIn.PreviousOut.Hash
+			if j, ok := indexes[*origin]; ok && j >= i {
+				return fmt.Errorf("transaction %v at index %d spends "+
+					"transaction %v at later index %d", tx.Hash(),
+					i, origin, j)
+			}
+		}
+	}
+	return nil
+}
+
+// CalcMerkleRootFromHashes returns the merkle root of a tree whose leaves are
+// the passed hashes, such as transaction hashes already known from the
+// transaction index.  It is the same root BuildMerkleTreeStore produces for the
+// transactions with those hashes, but the levels are reduced in place without
+// wrapping or hashing any transactions.  The zero hash is returned when there
+// are no hashes.
+func CalcMerkleRootFromHashes(txHashes []*hash.Hash) *hash.Hash {
+	if len(txHashes) == 0 {
+		return &hash.Hash{}
+	}
+
+	level := make([]hash.Hash, len(txHashes))
+	for i, h := range txHashes {
+		level[i] = *h
+	}
+	for len(level) > 1 {
+		// A node without a right sibling is hashed with itself.
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		for i := 0; i < len(level)/2; i++ {
+			level[i] = hashMerkleBranchesH(&level[2*i], &level[2*i+1])
+		}
+		level = level[:len(level)/2]
+	}
+	return &level[0]
+}
+
 // calcMerkleRoot creates a merkle tree from the slice of transactions and
 // returns the root of the tree.
 func calcMerkleRoot(txns []*types.Transaction) hash.Hash {
@@ -106,14 +379,20 @@ func calcMerkleRoot(txns []*types.Transaction) hash.Hash {
 	for _, tx := range txns {
 		utilTxns = append(utilTxns, types.NewTx(tx))
 	}
-	merkles := BuildMerkleTreeStore(utilTxns, false)
-	return *merkles[len(merkles)-1]
+	return CalcTxMerkleRoot(utilTxns, false)
 }
 
 // hashMerkleBranches takes two hashes, treated as the left and right tree
 // nodes, and returns the hash of their concatenation.  This is a helper
 // function used to aid in the generation of a merkle tree.
 func hashMerkleBranches(left *hash.Hash, right *hash.Hash) *hash.Hash {
+	newHash := hashMerkleBranchesH(left, right)
+	return &newHash
+}
+
+// hashMerkleBranchesH is like hashMerkleBranches but returns the hash by value
+// so callers which do not keep the node avoid an allocation.
+func hashMerkleBranchesH(left *hash.Hash, right *hash.Hash) hash.Hash {
 	// Concatenate the left and right nodes.
 	var h [hash.HashSize * 2]byte
 	copy(h[:hash.HashSize], left[:])
@@ -121,8 +400,7 @@ func hashMerkleBranches(left *hash.Hash, right *hash.Hash) *hash.Hash {
 
 	// TODO, add an abstract layer of hash func
 	// TODO, double sha256 or other crypto hash
-	newHash := hash.DoubleHashH(h[:])
-	return &newHash
+	return hash.DoubleHashH(h[:])
 }
 
 // nextPowerOfTwo returns the next highest power of two from a given number if
@@ -135,8 +413,7 @@ func nextPowerOfTwo(n int) int {
 	}
 
 	// Figure out and return the next power of two.
-	exponent := uint(math.Log2(float64(n))) + 1
-	return 1 << exponent // 2^exponent
+	return 1 << uint(bits.Len(uint(n))) // 2^exponent
 }
 
 // BuildParentsMerkleTreeStore creates a merkle tree from a slice of block parents,
@@ -212,6 +489,57 @@ func BuildParentsMerkleTreeStore(parents []*hash.Hash) []*hash.Hash {
 	return merkles
 }
 
+// CalcParentsRoot returns the merkle root committing to all of the passed block
+// parents as built by BuildParentsMerkleTreeStore.  The zero hash is returned
+// when there are no parents.
+func CalcParentsRoot(parents []*hash.Hash) *hash.Hash {
+	merkles := BuildParentsMerkleTreeStore(parents)
+	return merkles[len(merkles)-1]
+}
+
+// DiffMerkleTrees compares two merkle trees stored as linear arrays, such as
+// those returned by BuildMerkleTreeStore, and returns the index of the first
+// node that differs along with whether the trees differ at all.  Since leaves
+// are stored first, a differing leaf is found before the branches above it.
+// Trees of different sizes differ at the end of the shorter one when all of
+// its nodes match.  The index is -1 when the trees are identical.
+func DiffMerkleTrees(a, b []*hash.Hash) (int, bool) {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil || b[i] == nil:
+			return i, true
+		case !a[i].IsEqual(b[i]):
+			return i, true
+		}
+	}
+	if len(a) != len(b) {
+		return n, true
+	}
+	return -1, false
+}
+
+// BuildWitnessCommitment returns the witness commitment a coinbase with the
+// passed signature script must carry as the hash of its previous outpoint.  It
+// is the double hash of the witness merkle root of the transactions followed by
+// the coinbase signature script, which is what ValidateWitnessCommitment
+// checks.
+func BuildWitnessCommitment(transactions []*types.Tx, coinbaseWitness []byte) *hash.Hash {
+	witnessMerkleTree := BuildMerkleTreeStore(transactions, true)
+	witnessMerkleRoot := witnessMerkleTree[len(witnessMerkleTree)-1]
+
+	witnessPreimage := make([]byte, 0, hash.HashSize+len(coinbaseWitness))
+	witnessPreimage = append(witnessPreimage, witnessMerkleRoot.Bytes()...)
+	witnessPreimage = append(witnessPreimage, coinbaseWitness...)
+	commitment := hash.DoubleHashH(witnessPreimage)
+	return &commitment
+}
+
 func ValidateWitnessCommitment(blk *types.SerializedBlock) error {
 	if len(blk.Transactions()) == 0 {
 		str := "cannot validate witness commitment of block without " +
@@ -230,12 +558,7 @@ func ValidateWitnessCommitment(blk *types.SerializedBlock) error {
 	}
 
 	coinbase := coinbaseTx.Tx.TxIn[0].SignScript
-	witnessMerkleTree := BuildMerkleTreeStore(blk.Transactions(), true)
-	witnessMerkleRoot := witnessMerkleTree[len(witnessMerkleTree)-1]
-
-	witnessPreimage := append(witnessMerkleRoot.Bytes(), coinbase...)
-	computedCommitment := hash.DoubleHashH(witnessPreimage[:])
-
+	computedCommitment := BuildWitnessCommitment(blk.Transactions(), coinbase)
 	if !computedCommitment.IsEqual(&witnessCommitment) {
 		str := fmt.Sprintf("witness commitment does not match: "+
 			"computed %s, coinbase includes %s", computedCommitment,
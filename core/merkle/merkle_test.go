@@ -0,0 +1,499 @@
+package merkle
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTxns returns n distinct transactions, the first of which is a coinbase.
+func testTxns(n int) []*types.Tx {
+	txns := make([]*types.Tx, n)
+	for i := range txns {
+		tx := types.NewTransaction()
+		tx.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(&hash.Hash{}, types.MaxPrevOutIndex),
+			Sequence:    types.MaxTxInSequenceNum,
+			SignScript:  []byte{byte(i)},
+		})
+		tx.AddTxOut(&types.TxOutput{
+			Amount:   uint64(i+1) * 1e8,
+			PkScript: []byte{0x51},
+		})
+		txns[i] = types.NewTx(tx)
+	}
+	return txns
+}
+
+// TestCalcTxMerkleRoot ensures the small tree fast path produces the same root
+// as the full tree for every size around the cut-off.
+func TestCalcTxMerkleRoot(t *testing.T) {
+	for n := 0; n <= smallTreeMaxTxns+3; n++ {
+		txns := testTxns(n)
+		for _, witness := range []bool{false, true} {
+			merkles := BuildMerkleTreeStore(txns, witness)
+			want := *merkles[len(merkles)-1]
+			got := CalcTxMerkleRoot(txns, witness)
+			if got != want {
+				t.Errorf("CalcTxMerkleRoot(%d txns, witness %v): got %v, "+
+					"want %v", n, witness, got, want)
+			}
+		}
+	}
+}
+
+// TestCalcMerkleRootFromHashes ensures the root computed from the leaf hashes
+// matches the root of the full tree for a range of sizes.
+func TestCalcMerkleRootFromHashes(t *testing.T) {
+	for n := 0; n <= 9; n++ {
+		txns := testTxns(n)
+		txHashes := make([]*hash.Hash, n)
+		for i, tx := range txns {
+			txHashes[i] = tx.Hash()
+		}
+		merkles := BuildMerkleTreeStore(txns, false)
+		want := merkles[len(merkles)-1]
+		got := CalcMerkleRootFromHashes(txHashes)
+		if !got.IsEqual(want) {
+			t.Errorf("CalcMerkleRootFromHashes(%d hashes): got %v, "+
+				"want %v", n, got, want)
+		}
+	}
+}
+
+// TestCalcTxMerkleRootCached ensures cached roots match the uncached ones and
+// that repeated transaction sets are served from the cache.
+func TestCalcTxMerkleRootCached(t *testing.T) {
+	txns := testTxns(7)
+	for _, witness := range []bool{false, true} {
+		want := CalcTxMerkleRoot(txns, witness)
+		hits, _ := RootCacheStats()
+		for i := 0; i < 2; i++ {
+			got := CalcTxMerkleRootCached(txns, witness)
+			if got != want {
+				t.Fatalf("CalcTxMerkleRootCached(witness %v): got %v, "+
+					"want %v", witness, got, want)
+			}
+		}
+		if newHits, _ := RootCacheStats(); newHits != hits+1 {
+			t.Fatalf("root cache hits: got %d, want %d", newHits,
+				hits+1)
+		}
+	}
+
+	// A different order of the same transactions has a different root.
+	reordered := []*types.Tx{txns[0], txns[2], txns[1]}
+	if CalcTxMerkleRootCached(reordered, false) !=
+		CalcTxMerkleRoot(reordered, false) {
+		t.Fatal("CalcTxMerkleRootCached: wrong root for reordered txns")
+	}
+}
+
+// TestBuildMerkleTreeStoreInto ensures a reused buffer yields the same tree as
+// a fresh one, including the nil nodes left over from a larger previous tree.
+func TestBuildMerkleTreeStoreInto(t *testing.T) {
+	var buf []*hash.Hash
+	for _, n := range []int{9, 5, 0, 3, 9} {
+		txns := testTxns(n)
+		want := BuildMerkleTreeStore(txns, false)
+		got := BuildMerkleTreeStoreInto(buf, txns, false)
+		if len(got) != len(want) {
+			t.Fatalf("BuildMerkleTreeStoreInto(%d txns): got %d nodes, "+
+				"want %d", n, len(got), len(want))
+		}
+		for i := range want {
+			if (got[i] == nil) != (want[i] == nil) ||
+				got[i] != nil && !got[i].IsEqual(want[i]) {
+				t.Fatalf("BuildMerkleTreeStoreInto(%d txns): node %d "+
+					"is %v, want %v", n, i, got[i], want[i])
+			}
+		}
+		buf = got
+	}
+
+	// Once the buffer holds a tree of the same size, building a tree only allocates
+	// what hashing the transactions does.
+	txns := testTxns(9)
+	hashAllocs := testing.AllocsPerRun(10, func() {
+		for i, tx := range txns {
+			txLeafHash(tx, i, false)
+		}
+	})
+	allocs := testing.AllocsPerRun(10, func() {
+		buf = BuildMerkleTreeStoreInto(buf, txns, false)
+	})
+	if allocs != hashAllocs {
+		t.Fatalf("BuildMerkleTreeStoreInto: %v allocations, want %v",
+			allocs, hashAllocs)
+	}
+}
+
+// TestUpdateMerkleRoot ensures replacing a single leaf yields the same tree as
+// building it from scratch with the new leaf.
+func TestUpdateMerkleRoot(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		for index := 0; index < n; index++ {
+			txns := testTxns(n)
+			tree := BuildMerkleTreeStore(txns, false)
+
+			replaced := testTxns(n + 1)[n]
+			txns[index] = replaced
+			want := BuildMerkleTreeStore(txns, false)
+			got := UpdateMerkleRoot(tree, index, replaced.Hash())
+			if got == nil || !got.IsEqual(want[len(want)-1]) {
+				t.Fatalf("UpdateMerkleRoot(%d txns, leaf %d): got %v, "+
+					"want %v", n, index, got, want[len(want)-1])
+			}
+			for i := range want {
+				if (tree[i] == nil) != (want[i] == nil) ||
+					tree[i] != nil && !tree[i].IsEqual(want[i]) {
+					t.Fatalf("UpdateMerkleRoot(%d txns, leaf %d): "+
+						"node %d is %v, want %v", n, index, i,
+						tree[i], want[i])
+				}
+			}
+		}
+	}
+
+	tree := BuildMerkleTreeStore(testTxns(3), false)
+	for _, index := range []int{-1, 3, 4} {
+		if UpdateMerkleRoot(tree, index, &hash.Hash{}) != nil {
+			t.Errorf("UpdateMerkleRoot: unexpected root for leaf %d",
+				index)
+		}
+	}
+}
+
+// TestLeafHashes ensures the leaves match the start of the merkle tree and that
+// the witness tree uses the zero hash for the coinbase.
+func TestLeafHashes(t *testing.T) {
+	txns := testTxns(5)
+	for _, witness := range []bool{false, true} {
+		leaves := LeafHashes(txns, witness)
+		merkles := BuildMerkleTreeStore(txns, witness)
+		for i, leaf := range leaves {
+			if !leaf.IsEqual(merkles[i]) {
+				t.Errorf("LeafHashes(witness %v): leaf %d is %v, "+
+					"want %v", witness, i, leaf, merkles[i])
+			}
+		}
+	}
+	if leaves := LeafHashes(txns, true); !leaves[0].IsEqual(&hash.ZeroHash) {
+		t.Fatalf("LeafHashes: witness coinbase leaf is %v, want zero hash",
+			leaves[0])
+	}
+}
+
+// TestValidateMerkleRoot ensures the calculated root is accepted and any other
+// root is rejected.
+func TestValidateMerkleRoot(t *testing.T) {
+	for n := 1; n <= 6; n++ {
+		txns := testTxns(n)
+		for _, witness := range []bool{false, true} {
+			root := CalcTxMerkleRoot(txns, witness)
+			if err := ValidateMerkleRoot(txns, &root, witness); err != nil {
+				t.Errorf("ValidateMerkleRoot(%d txns, witness %v): %v",
+					n, witness, err)
+			}
+			root[0] ^= 0xff
+			if err := ValidateMerkleRoot(txns, &root, witness); err == nil {
+				t.Errorf("ValidateMerkleRoot(%d txns, witness %v): "+
+					"unexpected success with a wrong root", n, witness)
+			}
+		}
+	}
+}
+
+// TestValidateMerkleRootsParallel ensures blocks with valid roots pass and the
+// first block with an invalid root is reported.
+func TestValidateMerkleRootsParallel(t *testing.T) {
+	blocks := make([]*types.SerializedBlock, 20)
+	for i := range blocks {
+		block := &types.Block{
+			Header: types.BlockHeader{
+				Pow:       pow.GetInstance(pow.BLAKE2BD, 0, nil),
+				Timestamp: time.Unix(int64(i), 0),
+			},
+		}
+		for _, tx := range testTxns(i%5 + 1) {
+			block.AddTransaction(tx.Tx)
+		}
+		block.Header.TxRoot = CalcTxMerkleRoot(types.NewBlock(block).Transactions(), false)
+		blocks[i] = types.NewBlock(block)
+	}
+	for _, workers := range []int{0, 1, 4} {
+		if err := ValidateMerkleRootsParallel(blocks, workers); err != nil {
+			t.Fatalf("ValidateMerkleRootsParallel(%d workers): %v",
+				workers, err)
+		}
+	}
+
+	for _, i := range []int{15, 7} {
+		blocks[i].Block().Header.TxRoot[0] ^= 0xff
+	}
+	for _, workers := range []int{1, 4} {
+		err := ValidateMerkleRootsParallel(blocks, workers)
+		if err == nil || !strings.Contains(err.Error(), blocks[7].Hash().String()) {
+			t.Fatalf("ValidateMerkleRootsParallel(%d workers): got %v, "+
+				"want the error for block 7", workers, err)
+		}
+	}
+}
+
+// TestCheckTxOrder ensures transactions spending outputs of later transactions
+// of the block and misplaced coinbases are rejected.
+func TestCheckTxOrder(t *testing.T) {
+	txns := testTxns(2)
+	spend := func(prev *types.Tx) *types.Tx {
+		tx := types.NewTransaction()
+		tx.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(prev.Hash(), 0),
+			Sequence:    types.MaxTxInSequenceNum,
+		})
+		tx.AddTxOut(&types.TxOutput{Amount: 1e8, PkScript: []byte{0x51}})
+		return types.NewTx(tx)
+	}
+	parent := spend(txns[0])
+	child := spend(parent)
+
+	tests := []struct {
+		name  string
+		txns  []*types.Tx
+		valid bool
+	}{
+		{"empty", nil, true},
+		{"coinbase only", txns[:1], true},
+		{"ordered", []*types.Tx{txns[0], parent, child}, true},
+		{"child first", []*types.Tx{txns[0], child, parent}, false},
+		{"no coinbase", []*types.Tx{parent, child}, false},
+		{"second coinbase", []*types.Tx{txns[0], txns[1]}, false},
+	}
+	for _, test := range tests {
+		err := CheckTxOrder(test.txns)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid %v", test.name, err,
+				test.valid)
+		}
+	}
+}
+
+// TestDiffMerkleTrees ensures the first differing node of two merkle trees is
+// reported.
+func TestDiffMerkleTrees(t *testing.T) {
+	txns := testTxns(5)
+	base := BuildMerkleTreeStore(txns, false)
+
+	// Swap the order of the last two transactions, which first changes the
+	// fourth leaf.
+	swapped := append([]*types.Tx{}, txns...)
+	swapped[3], swapped[4] = swapped[4], swapped[3]
+
+	tests := []struct {
+		name    string
+		a, b    []*hash.Hash
+		index   int
+		differs bool
+	}{
+		{"identical", base, BuildMerkleTreeStore(txns, false), -1, false},
+		{"reordered", base, BuildMerkleTreeStore(swapped, false), 3, true},
+		{"shorter", base, BuildMerkleTreeStore(txns[:4], false), 4, true},
+		{"empty", nil, nil, -1, false},
+	}
+	for _, test := range tests {
+		index, differs := DiffMerkleTrees(test.a, test.b)
+		if index != test.index || differs != test.differs {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", test.name,
+				index, differs, test.index, test.differs)
+		}
+	}
+}
+
+// TestBuildWitnessCommitment ensures a commitment built for a block's coinbase
+// is accepted by ValidateWitnessCommitment.
+func TestBuildWitnessCommitment(t *testing.T) {
+	txns := testTxns(3)
+	coinbase := txns[0].Tx
+	commitment := BuildWitnessCommitment(txns, coinbase.TxIn[0].SignScript)
+	coinbase.TxIn[0].PreviousOut.Hash = *commitment
+	txns[0].RefreshHash()
+
+	block := &types.Block{
+		Header: types.BlockHeader{Pow: pow.GetInstance(pow.BLAKE2BD, 0, nil)},
+	}
+	for _, tx := range txns {
+		block.AddTransaction(tx.Tx)
+	}
+	if err := ValidateWitnessCommitment(types.NewBlock(block)); err != nil {
+		t.Fatalf("ValidateWitnessCommitment: %v", err)
+	}
+
+	// Changing the coinbase signature script must invalidate it.
+	coinbase.TxIn[0].SignScript = []byte{0x01, 0x02}
+	txns[0].RefreshHash()
+	if err := ValidateWitnessCommitment(types.NewBlock(block)); err == nil {
+		t.Fatal("ValidateWitnessCommitment: unexpected success after " +
+			"changing the coinbase")
+	}
+}
+
+// TestBuildMerkleProofs ensures every proof of a batch hashes up from its leaf
+// to the merkle root.
+func TestBuildMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txns := testTxns(n)
+		merkles := BuildMerkleTreeStore(txns, false)
+		root := merkles[len(merkles)-1]
+
+		indexes := make([]int, n)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		proofs, err := BuildMerkleProofs(txns, indexes, false)
+		if err != nil {
+			t.Fatalf("BuildMerkleProofs(%d txns): %v", n, err)
+		}
+		for index, proof := range proofs {
+			index0 := index
+			h := *txns[index].Hash()
+			for _, sibling := range proof {
+				if index&1 == 0 {
+					h = hashMerkleBranchesH(&h, sibling)
+				} else {
+					h = hashMerkleBranchesH(sibling, &h)
+				}
+				index /= 2
+			}
+			if !h.IsEqual(root) {
+				t.Errorf("BuildMerkleProofs(%d txns): proof for %d "+
+					"gives root %v, want %v", n, index0, h, root)
+			}
+		}
+	}
+
+	if _, err := BuildMerkleProofs(testTxns(2), []int{2}, false); err == nil {
+		t.Fatal("BuildMerkleProofs: unexpected success for out of " +
+			"range index")
+	}
+}
+
+// buildPartialTree encodes the partial merkle tree of the passed transaction
+// hashes matching the transactions at the passed indexes.
+func buildPartialTree(txHashes []*hash.Hash, match map[int]bool) ([]*hash.Hash, []byte) {
+	t := &partialTree{numTx: len(txHashes)}
+	var flags []bool
+	var calcHash func(height uint, pos int) *hash.Hash
+	calcHash = func(height uint, pos int) *hash.Hash {
+		if height == 0 {
+			return txHashes[pos]
+		}
+		left := calcHash(height-1, pos*2)
+		right := left
+		if pos*2+1 < t.width(height-1) {
+			right = calcHash(height-1, pos*2+1)
+		}
+		return hashMerkleBranches(left, right)
+	}
+	var build func(height uint, pos int)
+	build = func(height uint, pos int) {
+		parentOfMatch := false
+		for i := pos << height; i < (pos+1)<<height && i < t.numTx; i++ {
+			parentOfMatch = parentOfMatch || match[i]
+		}
+		flags = append(flags, parentOfMatch)
+		if height == 0 || !parentOfMatch {
+			t.hashes = append(t.hashes, calcHash(height, pos))
+			return
+		}
+		build(height-1, pos*2)
+		if pos*2+1 < t.width(height-1) {
+			build(height-1, pos*2+1)
+		}
+	}
+	var height uint
+	for t.width(height) > 1 {
+		height++
+	}
+	build(height, 0)
+
+	bits := make([]byte, (len(flags)+7)/8)
+	for i, flag := range flags {
+		if flag {
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return t.hashes, bits
+}
+
+// TestExtractMatchesFromPartialTree ensures a partial merkle tree yields the
+// block's merkle root and matched transactions and that malformed trees are
+// rejected.
+func TestExtractMatchesFromPartialTree(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txns := testTxns(n)
+		txHashes := make([]*hash.Hash, n)
+		for i, tx := range txns {
+			txHashes[i] = tx.Hash()
+		}
+		want := CalcMerkleRootFromHashes(txHashes)
+		match := map[int]bool{0: true, n - 1: true}
+
+		hashes, bits := buildPartialTree(txHashes, match)
+		root, matched, err := ExtractMatchesFromPartialTree(hashes, bits, n)
+		if err != nil {
+			t.Fatalf("ExtractMatchesFromPartialTree(%d txns): %v", n, err)
+		}
+		if !root.IsEqual(want) {
+			t.Errorf("ExtractMatchesFromPartialTree(%d txns): got root "+
+				"%v, want %v", n, root, want)
+		}
+		if len(matched) != len(match) ||
+			!matched[0].IsEqual(txHashes[0]) ||
+			!matched[len(matched)-1].IsEqual(txHashes[n-1]) {
+			t.Errorf("ExtractMatchesFromPartialTree(%d txns): wrong "+
+				"matches %v", n, matched)
+		}
+
+		// Extra hashes and missing flag bits must be rejected.
+		extra := append(append([]*hash.Hash{}, hashes...), txHashes[0])
+		if _, _, err := ExtractMatchesFromPartialTree(extra, bits, n); err == nil {
+			t.Errorf("ExtractMatchesFromPartialTree(%d txns): unexpected "+
+				"success with an extra hash", n)
+		}
+		if _, _, err := ExtractMatchesFromPartialTree(hashes, nil, n); err == nil {
+			t.Errorf("ExtractMatchesFromPartialTree(%d txns): unexpected "+
+				"success without flag bits", n)
+		}
+	}
+}
+
+func BenchmarkBuildMerkleTreeStoreInto(b *testing.B) {
+	txns := testTxns(100)
+	var buf []*hash.Hash
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = BuildMerkleTreeStoreInto(buf, txns, false)
+	}
+}
+
+func BenchmarkMerkleRootSmall(b *testing.B) {
+	for n := 1; n <= smallTreeMaxTxns; n++ {
+		txns := testTxns(n)
+		b.Run(fmt.Sprintf("tree/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				merkles := BuildMerkleTreeStore(txns, false)
+				_ = merkles[len(merkles)-1]
+			}
+		})
+		b.Run(fmt.Sprintf("direct/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				CalcTxMerkleRoot(txns, false)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package merkle
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+)
+
+// partialTree walks a partial merkle tree as sent in a merkle block message.
+// The tree is encoded depth first as a list of flag bits, one per visited node,
+// and the hashes of the nodes whose subtrees are not descended into.  A set
+// flag on an inner node means its subtree contains a matched transaction, and
+// a set flag on a leaf means the leaf itself is matched.
+type partialTree struct {
+	numTx      int
+	hashes     []*hash.Hash
+	bits       []byte
+	bitsUsed   int
+	hashesUsed int
+	matched    []*hash.Hash
+}
+
+// width returns the number of nodes at the passed height of the tree, where
+// the leaves are at height zero.
+func (t *partialTree) width(height uint) int {
+	return (t.numTx + (1 << height) - 1) >> height
+}
+
+// traverse returns the hash of the node at the passed height and position,
+// consuming flag bits and hashes as it descends.
+func (t *partialTree) traverse(height uint, pos int) (*hash.Hash, error) {
+	if t.bitsUsed >= len(t.bits)*8 {
+		return nil, fmt.Errorf("malformed partial merkle tree: ran out " +
+			"of flag bits")
+	}
+	flag := t.bits[t.bitsUsed/8]>>uint(t.bitsUsed%8)&1 == 1
+	t.bitsUsed++
+
+	if height == 0 || !flag {
+		if t.hashesUsed >= len(t.hashes) {
+			return nil, fmt.Errorf("malformed partial merkle tree: " +
+				"ran out of hashes")
+		}
+		h := t.hashes[t.hashesUsed]
+		t.hashesUsed++
+		if height == 0 && flag {
+			t.matched = append(t.matched, h)
+		}
+		return h, nil
+	}
+
+	left, err := t.traverse(height-1, pos*2)
+	if err != nil {
+		return nil, err
+	}
+	right := left
+	if pos*2+1 < t.width(height-1) {
+		right, err = t.traverse(height-1, pos*2+1)
+		if err != nil {
+			return nil, err
+		}
+		// Identical children would allow two different transaction
+		// lists to produce the same root.
+		if right.IsEqual(left) {
+			return nil, fmt.Errorf("malformed partial merkle tree: " +
+				"identical left and right branches")
+		}
+	}
+	return hashMerkleBranches(left, right), nil
+}
+
+// ExtractMatchesFromPartialTree walks the partial merkle tree of a block with
+// totalTx transactions described by the passed hashes and flag bits.  It
+// returns the merkle root the tree commits to along with the hashes of the
+// matched transactions in block order.  An error is returned when the tree is
+// malformed, such as when there are too many or too few hashes or flag bits.
+func ExtractMatchesFromPartialTree(hashes []*hash.Hash, bits []byte, totalTx int) (root *hash.Hash, matched []*hash.Hash, err error) {
+	if totalTx <= 0 {
+		return nil, nil, fmt.Errorf("malformed partial merkle tree: no " +
+			"transactions")
+	}
+	if len(hashes) > totalTx {
+		return nil, nil, fmt.Errorf("malformed partial merkle tree: %d "+
+			"hashes for %d transactions", len(hashes), totalTx)
+	}
+	if len(bits)*8 < len(hashes) {
+		return nil, nil, fmt.Errorf("malformed partial merkle tree: %d "+
+			"flag bytes for %d hashes", len(bits), len(hashes))
+	}
+
+	t := &partialTree{numTx: totalTx, hashes: hashes, bits: bits}
+	var height uint
+	for t.width(height) > 1 {
+		height++
+	}
+	root, err = t.traverse(height, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if t.hashesUsed != len(hashes) {
+		return nil, nil, fmt.Errorf("malformed partial merkle tree: %d "+
+			"unused hashes", len(hashes)-t.hashesUsed)
+	}
+	if (t.bitsUsed+7)/8 != len(bits) {
+		return nil, nil, fmt.Errorf("malformed partial merkle tree: %d "+
+			"flag bits used of %d bytes", t.bitsUsed, len(bits))
+	}
+	return root, t.matched, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"io"
+)
+
+// SerializeMerkleProof serializes a merkle proof made up of the sibling hashes
+// from the leaf up to the root and the index of the proven leaf.  The format is
+// the varint encoded leaf index, the varint encoded number of hashes and then
+// every hash as a fixed 32 bytes.
+func SerializeMerkleProof(proof []*hash.Hash, index int) []byte {
+	size := s.VarIntSerializeSize(uint64(index)) +
+		s.VarIntSerializeSize(uint64(len(proof))) + len(proof)*hash.HashSize
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+
+	// Writes to a bytes.Buffer never fail.
+	s.WriteVarInt(buf, 0, uint64(index))
+	s.WriteVarInt(buf, 0, uint64(len(proof)))
+	for _, h := range proof {
+		buf.Write(h[:])
+	}
+	return buf.Bytes()
+}
+
+// DeserializeMerkleProof decodes a merkle proof serialized by
+// SerializeMerkleProof and returns its sibling hashes and leaf index.  An error
+// is returned when the input is truncated or has trailing bytes.
+func DeserializeMerkleProof(serialized []byte) ([]*hash.Hash, int, error) {
+	r := bytes.NewReader(serialized)
+	index, err := s.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed merkle proof: unable to "+
+			"read leaf index: %v", err)
+	}
+	count, err := s.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed merkle proof: unable to "+
+			"read hash count: %v", err)
+	}
+
+	// Ensure the claimed number of hashes is actually present before
+	// allocating space for them.
+	if count > uint64(r.Len()/hash.HashSize) {
+		return nil, 0, fmt.Errorf("malformed merkle proof: truncated "+
+			"input with %d bytes for %d hashes", r.Len(), count)
+	}
+
+	proof := make([]*hash.Hash, count)
+	for i := range proof {
+		var h hash.Hash
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, 0, fmt.Errorf("malformed merkle proof: unable "+
+				"to read hash %d: %v", i, err)
+		}
+		proof[i] = &h
+	}
+	if r.Len() != 0 {
+		return nil, 0, fmt.Errorf("malformed merkle proof: %d trailing "+
+			"bytes", r.Len())
+	}
+	return proof, int(index), nil
+}
+
+// BuildMerkleProofs builds the merkle tree of the passed transactions once and
+// returns the proof for the transaction at each of the passed indexes, keyed by
+// index.  Every proof holds the sibling hashes from the leaf up to the root,
+// where a node without a right sibling is paired with itself.  An error is
+// returned when an index is out of range.
+func BuildMerkleProofs(transactions []*types.Tx, indexes []int, witness bool) (map[int][]*hash.Hash, error) {
+	for _, index := range indexes {
+		if index < 0 || index >= len(transactions) {
+			return nil, fmt.Errorf("transaction index %d is out of "+
+				"range for %d transactions", index, len(transactions))
+		}
+	}
+
+	merkles := BuildMerkleTreeStore(transactions, witness)
+	width := nextPowerOfTwo(len(transactions))
+	proofs := make(map[int][]*hash.Hash, len(indexes))
+	for _, index := range indexes {
+		if _, ok := proofs[index]; ok {
+			continue
+		}
+		proofs[index] = merkleProof(merkles, width, index)
+	}
+	return proofs, nil
+}
+
+// merkleProof extracts the proof for the leaf at the passed index from a merkle
+// tree stored as a linear array by BuildMerkleTreeStore with the passed number
+// of leaf slots.
+func merkleProof(merkles []*hash.Hash, width int, index int) []*hash.Hash {
+	var proof []*hash.Hash
+	levelStart := 0
+	for ; width > 1; width /= 2 {
+		sibling := merkles[levelStart+(index^1)]
+		if sibling == nil {
+			sibling = merkles[levelStart+index]
+		}
+		proof = append(proof, sibling)
+		levelStart += width
+		index /= 2
+	}
+	return proof
+}
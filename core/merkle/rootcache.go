@@ -0,0 +1,104 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package merkle
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"sync"
+)
+
+// rootCacheSize is the maximum number of merkle roots kept by the root cache.
+// Once it is full the oldest entry is evicted.
+const rootCacheSize = 256
+
+// rootCache is a bounded, concurrency safe cache of merkle roots keyed by the
+// hash of the ordered leaf hashes they were computed from.  Since the order of
+// the leaves fully determines the root, the key identifies the root exactly.
+type rootCache struct {
+	mtx    sync.Mutex
+	roots  map[hash.Hash]hash.Hash
+	keys   []hash.Hash // insertion order, used as a ring for eviction
+	next   int
+	hits   uint64
+	misses uint64
+}
+
+// txRootCache is the cache used by CalcTxMerkleRootCached.
+var txRootCache = &rootCache{
+	roots: make(map[hash.Hash]hash.Hash, rootCacheSize),
+	keys:  make([]hash.Hash, 0, rootCacheSize),
+}
+
+// lookup returns the root cached for key, if any.
+func (c *rootCache) lookup(key *hash.Hash) (hash.Hash, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	root, ok := c.roots[*key]
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return root, ok
+}
+
+// add caches root for key, evicting the oldest entry when the cache is full.
+func (c *rootCache) add(key *hash.Hash, root *hash.Hash) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.roots[*key]; ok {
+		return
+	}
+	if len(c.keys) < rootCacheSize {
+		c.keys = append(c.keys, *key)
+	} else {
+		delete(c.roots, c.keys[c.next])
+		c.keys[c.next] = *key
+		c.next = (c.next + 1) % rootCacheSize
+	}
+	c.roots[*key] = *root
+}
+
+// rootCacheKey returns the cache key for the passed transactions, which is the
+// hash of their concatenated leaf hashes prefixed by the tree type.
+func rootCacheKey(transactions []*types.Tx, witness bool) hash.Hash {
+	buf := make([]byte, 1, 1+len(transactions)*hash.HashSize)
+	if witness {
+		buf[0] = 1
+	}
+	for i, tx := range transactions {
+		leaf := txLeafHash(tx, i, witness)
+		buf = append(buf, leaf[:]...)
+	}
+	return hash.HashH(buf)
+}
+
+// CalcTxMerkleRootCached is like CalcTxMerkleRoot except that recently
+// computed roots are cached, keyed by the ordered leaf hashes.  Rebuilding the
+// merkle root of a transaction set seen before, such as when a block template
+// is regenerated without changes to its transactions, hashes the leaves once
+// instead of building the whole tree.
+//
+// This function is safe for concurrent access.
+func CalcTxMerkleRootCached(transactions []*types.Tx, witness bool) hash.Hash {
+	key := rootCacheKey(transactions, witness)
+	if root, ok := txRootCache.lookup(&key); ok {
+		return root
+	}
+	root := CalcTxMerkleRoot(transactions, witness)
+	txRootCache.add(&key, &root)
+	return root
+}
+
+// RootCacheStats returns the number of CalcTxMerkleRootCached calls served
+// from and missed by the root cache.
+//
+// This function is safe for concurrent access.
+func RootCacheStats() (hits uint64, misses uint64) {
+	txRootCache.mtx.Lock()
+	defer txRootCache.mtx.Unlock()
+	return txRootCache.hits, txRootCache.misses
+}
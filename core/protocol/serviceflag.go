@@ -45,6 +45,22 @@ func (f ServiceFlag) String() string {
 	return s
 }
 
+// List returns the name of every flag set in the ServiceFlag.  Any remaining
+// flags which aren't accounted for are added as a single hex entry.
+func (f ServiceFlag) List() []string {
+	list := []string{}
+	for _, flag := range orderedSFStrings {
+		if f&flag == flag {
+			list = append(list, sfStrings[flag])
+			f -= flag
+		}
+	}
+	if f != 0 {
+		list = append(list, "0x"+strconv.FormatUint(uint64(f), 16))
+	}
+	return list
+}
+
 // hasServices returns whether or not the provided advertised service flags have
 // all of the provided desired service flags set.
 func HasServices(advertised, desired ServiceFlag) bool {
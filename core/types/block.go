@@ -414,6 +414,7 @@ type SerializedBlock struct {
 	serializedBytes []byte    // Serialized bytes for the block
 	transactions    []*Tx     // Transactions
 	txnsGenerated   bool      // ALL wrapped transactions generated
+	txLocs          []TxLoc   // Cached transaction locations
 	order           uint64    //order is in the position of whole block chain.
 	height          uint      //height is in the sub dag chain.
 }
@@ -529,8 +530,13 @@ func (sb *SerializedBlock) Bytes() ([]byte, error) {
 
 // TxLoc returns the offsets and lengths of each transaction in a raw block.
 // It is used to allow fast indexing into transactions within the raw byte
-// stream.
+// stream.  The locations are cached so subsequent calls are more efficient.
 func (sb *SerializedBlock) TxLoc() ([]TxLoc, error) {
+	// Return the cached locations if they have already been generated.
+	if sb.txLocs != nil {
+		return sb.txLocs, nil
+	}
+
 	rawMsg, err := sb.Bytes()
 	if err != nil {
 		return nil, err
@@ -542,6 +548,7 @@ func (sb *SerializedBlock) TxLoc() ([]TxLoc, error) {
 	if err != nil {
 		return nil, err
 	}
+	sb.txLocs = txLocs
 	return txLocs, err
 }
 
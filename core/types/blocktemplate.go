@@ -55,6 +55,12 @@ type BlockTemplate struct {
 	// templates without a coinbase payment address.
 	ValidPayAddress bool
 
+	// ExtraNonceOffset and ExtraNonceSize describe the extra nonce region
+	// of the coinbase signature script, the byte range miners may
+	// overwrite to produce unique work from a single template.
+	ExtraNonceOffset int
+	ExtraNonceSize   int
+
 	//pow diff standard
 	PowDiffData PowDiffStandard
 }
@@ -75,9 +75,78 @@ func (api *PublicBlockChainAPI) GetNodeInfo() (interface{}, error) {
 		Modules:          []string{rpc.DefaultServiceNameSpace, rpc.MinerNameSpace, rpc.TestNameSpace, rpc.LogNameSpace},
 	}
 	ret.GraphState = *getGraphStateResult(best.GraphState)
+	ret.BlockTotal = uint64(best.GraphState.GetTotal())
+	ret.TipCount = best.GraphState.GetTips().Size()
+	ret.Indexes = []string{}
+	if api.node.indexManager != nil {
+		ret.Indexes = api.node.indexManager.IndexNames()
+	}
 	return ret, nil
 }
 
+// defaultHashPSBlocks is the number of blocks getnetworkhashps looks back over
+// when the caller does not ask for a positive window.
+const defaultHashPSBlocks = 120
+
+// Return the estimated network hashes per second for each pow type, computed
+// from the work and timestamps of the most recent blocks by order.  The
+// estimate can be limited to a single pow type by passing its name.
+func (api *PublicBlockChainAPI) GetNetworkHashPS(blocks int, powType string) (interface{}, error) {
+	if blocks <= 0 {
+		blocks = defaultHashPSBlocks
+	}
+	works := make(map[pow.PowType]*big.Int)
+	for pt, name := range pow.PowMapString {
+		if powType == "" || powType == name.(string) {
+			works[pt] = big.NewInt(0)
+		}
+	}
+	if len(works) == 0 {
+		return nil, rpc.RpcInvalidError("Invalid pow type %s", powType)
+	}
+
+	bc := api.node.blockManager.GetChain()
+	best := bc.BestSnapshot()
+	var minTime, maxTime int64
+	order := int64(best.GraphState.GetMainOrder())
+	for i := 0; i < blocks && order >= 0; i, order = i+1, order-1 {
+		h := bc.BlockDAG().GetBlockByOrder(uint(order))
+		if h == nil {
+			continue
+		}
+		node := bc.BlockIndex().LookupNode(h)
+		if node == nil {
+			continue
+		}
+		ts := node.GetTimestamp()
+		if minTime == 0 || ts < minTime {
+			minTime = ts
+		}
+		if ts > maxTime {
+			maxTime = ts
+		}
+		// The oldest block only marks the start of the window, its work
+		// was done before the window began.
+		if i == blocks-1 || order == 0 {
+			continue
+		}
+		if work, ok := works[node.GetPowType()]; ok {
+			work.Add(work, pow.CalcWork(node.Header().Difficulty, node.GetPowType()))
+		}
+	}
+
+	result := make(json.GetNetworkHashPSResult, len(works))
+	span := maxTime - minTime
+	for pt, work := range works {
+		hashPS := float64(0)
+		if span > 0 {
+			hashPS, _ = new(big.Rat).SetFrac(work, big.NewInt(span)).Float64()
+		}
+		result[pow.PowMapString[pt].(string)] = hashPS
+	}
+	return result, nil
+}
+
 // getDifficultyRatio returns the proof-of-work difficulty as a multiple of the
 // minimum difficulty using the passed bits field from the header of a block.
 func getDifficultyRatio(target *big.Int, params *params.Params, powType pow.PowType) float64 {
@@ -112,38 +181,63 @@ func (api *PublicBlockChainAPI) GetPeerInfo() (interface{}, error) {
 	for _, p := range peers {
 		statsSnap := p.StatsSnapshot()
 		info := &json.GetPeerInfoResult{
-			UUID:       statsSnap.UUID.String(),
-			ID:         statsSnap.ID,
-			Addr:       statsSnap.Addr,
-			AddrLocal:  p.LocalAddr().String(),
-			Services:   fmt.Sprintf("%08d", uint64(statsSnap.Services)),
-			RelayTxes:  !p.IsTxRelayDisabled(),
-			LastSend:   statsSnap.LastSend.Unix(),
-			LastRecv:   statsSnap.LastRecv.Unix(),
-			BytesSent:  statsSnap.BytesSent,
-			BytesRecv:  statsSnap.BytesRecv,
-			ConnTime:   statsSnap.ConnTime.Unix(),
-			PingTime:   float64(statsSnap.LastPingMicros),
-			TimeOffset: statsSnap.TimeOffset,
-			Version:    statsSnap.Version,
-			SubVer:     statsSnap.UserAgent,
-			Inbound:    statsSnap.Inbound,
-			BanScore:   int32(p.BanScore()),
-			SyncNode:   statsSnap.ID == syncPeerID,
+			UUID:         statsSnap.UUID.String(),
+			ID:           statsSnap.ID,
+			Addr:         statsSnap.Addr,
+			AddrLocal:    p.LocalAddr().String(),
+			Services:     fmt.Sprintf("%08d", uint64(statsSnap.Services)),
+			ServicesList: statsSnap.Services.List(),
+			RelayTxes:    !p.IsTxRelayDisabled(),
+			LastSend:     statsSnap.LastSend.Unix(),
+			LastRecv:     statsSnap.LastRecv.Unix(),
+			BytesSent:    statsSnap.BytesSent,
+			BytesRecv:    statsSnap.BytesRecv,
+			ConnTime:     statsSnap.ConnTime.Unix(),
+			PingTime:     float64(statsSnap.LastPingMicros) / 1e6,
+			MinPing:      float64(statsSnap.MinPingMicros) / 1e6,
+			TimeOffset:   statsSnap.TimeOffset,
+			Version:      statsSnap.Version,
+			SubVer:       statsSnap.UserAgent,
+			Inbound:      statsSnap.Inbound,
+			BanScore:     int32(p.BanScore()),
+			SyncNode:     statsSnap.ID == syncPeerID,
 		}
 		if statsSnap.GraphState != nil {
 			info.GraphState = *getGraphStateResult(statsSnap.GraphState)
+
+			// The graph state only announces the order of the main
+			// chain tip, so that tip is reported as the best one.
+			info.BestHash = statsSnap.GraphState.GetMainChainTip().String()
+			info.BestOrder = uint32(statsSnap.GraphState.GetMainOrder())
 		}
 		if p.LastPingNonce() != 0 {
-			wait := float64(time.Since(statsSnap.LastPingTime).Nanoseconds())
-			// We actually want microseconds.
-			info.PingWait = wait / 1000
+			// Ping times are reported in seconds.
+			info.PingWait = time.Since(statsSnap.LastPingTime).Seconds()
 		}
 		infos = append(infos, info)
 	}
 	return infos, nil
 }
 
+// Return the sync progress of the node, comparing the local graph state with
+// the best graph state announced by the connected peers.
+func (api *PublicBlockChainAPI) GetSyncProgress() (interface{}, error) {
+	best := api.node.blockManager.GetChain().BestSnapshot()
+	bestGS := best.GraphState
+	for _, p := range api.node.node.peerServer.ConnectedPeers() {
+		gs := p.StatsSnapshot().GraphState
+		if gs != nil && gs.IsExcellent(bestGS) {
+			bestGS = gs
+		}
+	}
+	ret := &json.GetSyncProgressResult{
+		Current: *getGraphStateResult(best.GraphState),
+		Best:    *getGraphStateResult(bestGS),
+	}
+	ret.Progress = json.SyncProgress(&ret.Current, &ret.Best)
+	return ret, nil
+}
+
 // Return the RPC info
 func (api *PublicBlockChainAPI) GetRpcInfo() (interface{}, error) {
 	rs := api.node.node.rpcServer.ReqStatus
@@ -196,7 +290,13 @@ func (api *PrivateBlockChainAPI) Banlist() (interface{}, error) {
 	bl := api.node.node.peerServer.GetBanlist()
 	bls := []*json.GetBanlistResult{}
 	for k, v := range bl {
-		bls = append(bls, &json.GetBanlistResult{Host: k, Expire: v.String()})
+		bls = append(bls, &json.GetBanlistResult{
+			Host:        k,
+			Address:     v.Addr,
+			Expire:      v.Until.String(),
+			BannedUntil: v.Until.Unix(),
+			Reason:      v.Reason,
+		})
 	}
 	return bls, nil
 }
@@ -46,6 +46,9 @@ type QitmeerFull struct {
 	timeSource blockchain.MedianTimeSource
 	// signature cache
 	sigCache *txscript.SigCache
+
+	// optional indexes, nil when none are enabled
+	indexManager *index.Manager
 }
 
 func (qm *QitmeerFull) Start(server *peerserver.PeerServer) error {
@@ -110,7 +113,14 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 	var txIndex *index.TxIndex
 	var addrIndex *index.AddrIndex
 	log.Info("Transaction index is enabled")
-	txIndex = index.NewTxIndex(qm.db)
+	txIndex = index.NewTxIndexWithOptions(qm.db, index.TxIndexOptions{
+		CacheSize:    cfg.TxIndexCache,
+		Strict:       cfg.TxIndexStrict,
+		SideChain:    cfg.TxIndexSideChain,
+		Compact:      cfg.TxIndexCompact,
+		Filter:       cfg.TxIndexFilter,
+		FilterFPRate: cfg.TxIndexFilterFPRate,
+	})
 	indexes = append(indexes, txIndex)
 	if cfg.AddrIndex {
 		log.Info("Address index is enabled")
@@ -120,7 +130,12 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 	// index-manager
 	var indexManager blockchain.IndexManager
 	if len(indexes) > 0 {
-		indexManager = index.NewManager(qm.db, indexes, node.Params)
+		qm.indexManager = index.NewManager(qm.db, indexes, node.Params)
+		if cfg.IndexCatchUpBlocks > 0 {
+			qm.indexManager.SetCatchUpRate(cfg.IndexCatchUpBlocks,
+				cfg.IndexCatchUpInterval)
+		}
+		indexManager = qm.indexManager
 	}
 
 	qm.nfManager = &notifymgr.NotifyMgr{Server: node.peerServer, RpcServer: node.rpcServer}
@@ -150,10 +165,11 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 	// NOTE: The CPU miner relies on the mempool, so the mempool has to be
 	// created before calling the function to create the CPU miner.
 	policy := mining.Policy{
-		BlockMinSize:      cfg.BlockMinSize,
-		BlockMaxSize:      cfg.BlockMaxSize,
-		BlockPrioritySize: cfg.BlockPrioritySize,
-		TxMinFreeFee:      cfg.MinTxFee, //TODO, duplicated config item with mem-pool
+		BlockMinSize:           cfg.BlockMinSize,
+		BlockMaxSize:           cfg.BlockMaxSize,
+		BlockPrioritySize:      cfg.BlockPrioritySize,
+		CoinbaseExtraNonceSize: cfg.CoinbaseExtraNonce,
+		TxMinFreeFee:           cfg.MinTxFee, //TODO, duplicated config item with mem-pool
 		StandardVerifyFlags: func() (txscript.ScriptFlags, error) {
 			return common.StandardScriptVerifyFlags()
 		}, //TODO, duplicated config item with mem-pool
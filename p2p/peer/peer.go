@@ -41,6 +41,7 @@ type StatsSnap struct {
 	LastPingNonce  uint64
 	LastPingTime   time.Time
 	LastPingMicros int64
+	MinPingMicros  int64
 	GraphState     *blockdag.GraphState
 }
 
@@ -586,6 +587,7 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 		Inbound:        p.inbound,
 		LastPingNonce:  p.lastPingNonce,
 		LastPingMicros: p.lastPingMicros,
+		MinPingMicros:  p.minPingMicros,
 		LastPingTime:   p.lastPingTime,
 		GraphState:     p.lastGS,
 	}
@@ -288,6 +288,9 @@ func (p *Peer) handlePongMsg(msg *message.MsgPong) {
 	if p.lastPingNonce != 0 && msg.Nonce == p.lastPingNonce {
 		p.lastPingMicros = time.Since(p.lastPingTime).Nanoseconds()
 		p.lastPingMicros /= 1000 // convert to usec.
+		if p.minPingMicros == 0 || p.lastPingMicros < p.minPingMicros {
+			p.minPingMicros = p.lastPingMicros
+		}
 		p.lastPingNonce = 0
 	}
 	p.statsMtx.Unlock()
@@ -102,6 +102,7 @@ type Peer struct {
 	lastPingNonce  uint64    // Set to nonce if we have a pending ping.
 	lastPingTime   time.Time // Time we sent last ping.
 	lastPingMicros int64     // Time for last ping to return.
+	minPingMicros  int64     // Lowest time for a ping to return.
 
 	// These fields are chans for peer msg handling
 	//  - quit
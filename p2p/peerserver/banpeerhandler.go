@@ -9,8 +9,9 @@ import (
 )
 
 type BanPeerMsg struct {
-	sp  *serverPeer
-	dur time.Duration
+	sp     *serverPeer
+	dur    time.Duration
+	reason string
 }
 
 // BanPeer bans a peer that has already been connected to the server by ip.
@@ -29,7 +30,11 @@ func (s *PeerServer) handleBanPeerMsg(state *peerState, msg *BanPeerMsg) {
 	direction := directionString(msg.sp.Inbound())
 	log.Info(fmt.Sprintf("Banned peer %s (%s) for %v", host, direction,
 		connmgr.BanDuration))
-	state.banned[host] = time.Now().Add(msg.dur)
+	state.banned[host] = &BanInfo{
+		Until:  time.Now().Add(msg.dur),
+		Reason: msg.reason,
+		Addr:   msg.sp.Addr(),
+	}
 }
 
 // addBanScore increases the persistent and decaying ban score fields by the
@@ -66,7 +71,7 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 			log.Warn("Misbehaving peer -- banning and disconnecting", "peer", sp)
 			dur := float64(transient) / float64(connmgr.BanThreshold)
 			dur *= float64(connmgr.BanDuration)
-			msg := BanPeerMsg{sp: sp, dur: time.Duration(dur)}
+			msg := BanPeerMsg{sp: sp, dur: time.Duration(dur), reason: reason}
 			if msg.dur > connmgr.BanDuration {
 				msg.dur = connmgr.BanDuration
 			}
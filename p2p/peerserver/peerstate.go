@@ -6,13 +6,26 @@ import (
 	"time"
 )
 
+// BanInfo describes a banned host.
+type BanInfo struct {
+	// Until is when the ban expires.
+	Until time.Time
+
+	// Reason is the misbehavior that pushed the peer over the ban
+	// threshold.
+	Reason string
+
+	// Addr is the full address, including the port, of the banned peer.
+	Addr string
+}
+
 // peerState maintains state of inbound, persistent, outbound peers as well
 // as banned peers and outbound groups.
 type peerState struct {
 	inboundPeers    map[int32]*serverPeer
 	outboundPeers   map[int32]*serverPeer
 	persistentPeers map[int32]*serverPeer
-	banned          map[string]time.Time
+	banned          map[string]*BanInfo
 	outboundGroups  map[string]int
 }
 
@@ -43,10 +56,10 @@ func (ps *peerState) forAllOutboundPeers(closure func(sp *serverPeer)) {
 }
 
 func (ps *peerState) IsBanPeer(host string) bool {
-	if banEnd, ok := ps.banned[host]; ok {
-		if time.Now().Before(banEnd) {
+	if ban, ok := ps.banned[host]; ok {
+		if time.Now().Before(ban.Until) {
 			log.Debug(fmt.Sprintf("Peer %s is banned for another %v - disconnecting",
-				host, time.Until(banEnd)))
+				host, time.Until(ban.Until)))
 			return true
 		}
 		log.Info("Peer is no longer banned", "peer", host)
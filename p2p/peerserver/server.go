@@ -341,7 +341,7 @@ func (s *PeerServer) peerHandler() {
 		inboundPeers:    make(map[int32]*serverPeer),
 		persistentPeers: make(map[int32]*serverPeer),
 		outboundPeers:   make(map[int32]*serverPeer),
-		banned:          make(map[string]time.Time),
+		banned:          make(map[string]*BanInfo),
 		outboundGroups:  make(map[string]int),
 	}
 	s.state = state
@@ -457,13 +457,18 @@ func (s *PeerServer) HasPeer(uuid uuid.UUID) bool {
 	return <-replyChan
 }
 
-func (s *PeerServer) GetBanlist() map[string]time.Time {
-	return s.state.banned
+// GetBanlist returns a copy of the ban details of every banned host.
+func (s *PeerServer) GetBanlist() map[string]BanInfo {
+	bans := make(map[string]BanInfo, len(s.state.banned))
+	for host, ban := range s.state.banned {
+		bans[host] = *ban
+	}
+	return bans
 }
 
 func (s *PeerServer) RemoveBan(host string) {
 	if len(host) == 0 {
-		s.state.banned = map[string]time.Time{}
+		s.state.banned = map[string]*BanInfo{}
 		log.Trace("Remove all ban")
 		return
 	}
@@ -319,6 +319,11 @@ function tips(){
   get_result "$data"
 }
 
+function get_chain_tips(){
+  local data='{"jsonrpc":"2.0","method":"getChainTips","params":[],"id":null}'
+  get_result "$data"
+}
+
 function get_coinbase(){
   local block_hash=$1
   local verbose=$2
@@ -468,6 +473,7 @@ function usage(){
   echo "  isblue <hash>   ;return [0:not blue;  1：blue  2：Cannot confirm]"
   echo "  iscurrent"
   echo "  tips"
+  echo "  chaintips"
   echo "  coinbase <hash>"
   echo "  fees <hash>"
   echo "tx     :"
@@ -806,6 +812,10 @@ elif [ "$1" == "tips" ]; then
   shift
   tips | jq .
 
+elif [ "$1" == "chaintips" ]; then
+  shift
+  get_chain_tips | jq .
+
 elif [ "$1" == "coinbase" ]; then
   shift
   get_coinbase $@
@@ -387,6 +387,49 @@ func (api *PublicBlockAPI) Tips() (interface{}, error) {
 	return tips, nil
 }
 
+// Return every tip of the DAG along with the tips of the block index that are
+// known to be invalid.  The main chain tip is active and the other DAG tips are
+// valid forks, whose branch length is the number of blocks between the tip and
+// the main chain following main parents.  The branch length of an invalid tip
+// is the number of invalid blocks it builds on, including itself.
+func (api *PublicBlockAPI) GetChainTips() (interface{}, error) {
+	bd := api.bm.chain.BlockDAG()
+	mainTip := bd.GetMainChainTip()
+	tips := []json.GetChainTipsResult{}
+	for _, tip := range bd.GetTipsList() {
+		result := json.GetChainTipsResult{
+			Hash:   tip.GetHash().String(),
+			Height: uint64(tip.GetHeight()),
+			Layer:  uint32(tip.GetLayer()),
+			Order:  uint32(tip.GetOrder()),
+			Status: "valid-fork",
+		}
+		if tip.GetHash().IsEqual(mainTip.GetHash()) {
+			result.Status = "active"
+		} else {
+			for cur := tip; cur != nil && !bd.IsOnMainChain(cur.GetID()); cur = bd.GetBlockById(cur.GetMainParent()) {
+				result.BranchLen++
+			}
+		}
+		tips = append(tips, result)
+	}
+	index := api.bm.chain.BlockIndex()
+	for _, tip := range index.InvalidTips() {
+		result := json.GetChainTipsResult{
+			Hash:   tip.GetHash().String(),
+			Height: uint64(tip.GetHeight()),
+			Layer:  uint32(tip.GetLayer()),
+			Order:  uint32(tip.GetOrder()),
+			Status: "invalid",
+		}
+		for cur := tip; cur != nil && index.NodeStatus(cur).KnownInvalid(); cur = cur.GetBackParent() {
+			result.BranchLen++
+		}
+		tips = append(tips, result)
+	}
+	return tips, nil
+}
+
 // GetCoinbase
 func (api *PublicBlockAPI) GetCoinbase(h hash.Hash, verbose *bool) (interface{}, error) {
 	vb := false
@@ -13,6 +13,7 @@ import (
 	"github.com/Qitmeer/qitmeer/p2p/peer"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/mempool"
+	"github.com/Qitmeer/qitmeer/services/mining"
 	"github.com/Qitmeer/qitmeer/version"
 	"github.com/jessevdk/go-flags"
 	"net"
@@ -20,6 +21,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
 const (
@@ -38,6 +40,7 @@ const (
 	defaultMaxInboundPeersPerHost = 10 // The default max total of inbound peer for host
 	defaultTrickleInterval        = peer.TrickleTimeout
 	defaultCacheInvalidTx         = false
+	defaultIndexCatchUpInterval   = time.Second
 )
 const (
 	defaultSigCacheMaxSize = 100000
@@ -63,28 +66,31 @@ func LoadConfig() (*config.Config, []string, error) {
 
 	// Default config.
 	cfg := config.Config{
-		HomeDir:           defaultHomeDir,
-		ConfigFile:        defaultConfigFile,
-		DebugLevel:        defaultLogLevel,
-		DebugPrintOrigins: defaultDebugPrintOrigins,
-		DataDir:           defaultDataDir,
-		LogDir:            defaultLogDir,
-		DbType:            defaultDbType,
-		RPCKey:            defaultRPCKeyFile,
-		RPCCert:           defaultRPCCertFile,
-		RPCMaxClients:     defaultMaxRPCClients,
-		Generate:          defaultGenerate,
-		MaxPeers:          defaultMaxPeers,
-		MinTxFee:          mempool.DefaultMinRelayTxFee,
-		BlockMinSize:      defaultBlockMinSize,
-		BlockMaxSize:      defaultBlockMaxSize,
-		SigCacheMaxSize:   defaultSigCacheMaxSize,
-		MiningStateSync:   defaultMiningStateSync,
-		DAGType:           defaultDAGType,
-		Banning:           false,
-		MaxInbound:        defaultMaxInboundPeersPerHost,
-		TrickleInterval:   defaultTrickleInterval,
-		CacheInvalidTx:    defaultCacheInvalidTx,
+		HomeDir:            defaultHomeDir,
+		ConfigFile:         defaultConfigFile,
+		DebugLevel:         defaultLogLevel,
+		DebugPrintOrigins:  defaultDebugPrintOrigins,
+		DataDir:            defaultDataDir,
+		LogDir:             defaultLogDir,
+		DbType:             defaultDbType,
+		RPCKey:             defaultRPCKeyFile,
+		RPCCert:            defaultRPCCertFile,
+		RPCMaxClients:      defaultMaxRPCClients,
+		Generate:           defaultGenerate,
+		MaxPeers:           defaultMaxPeers,
+		MinTxFee:           mempool.DefaultMinRelayTxFee,
+		BlockMinSize:       defaultBlockMinSize,
+		BlockMaxSize:       defaultBlockMaxSize,
+		CoinbaseExtraNonce: mining.DefaultCoinbaseExtraNonceSize,
+		SigCacheMaxSize:    defaultSigCacheMaxSize,
+		MiningStateSync:    defaultMiningStateSync,
+		DAGType:            defaultDAGType,
+		Banning:            false,
+		MaxInbound:         defaultMaxInboundPeersPerHost,
+		TrickleInterval:    defaultTrickleInterval,
+		CacheInvalidTx:     defaultCacheInvalidTx,
+
+		IndexCatchUpInterval: defaultIndexCatchUpInterval,
 	}
 
 	// Pre-parse the command line options to see if an alternative config
@@ -321,6 +327,38 @@ func LoadConfig() (*config.Config, []string, error) {
 		return nil, nil, err
 	}
 
+	// The transaction index cache can not have a negative size.
+	if cfg.TxIndexCache < 0 {
+		str := "%s: the txindexcache option may not be negative -- " +
+			"parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.TxIndexCache)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The false positive rate of the transaction filter must be a rate.
+	if cfg.TxIndexFilterFPRate < 0 || cfg.TxIndexFilterFPRate >= 1 {
+		str := "%s: the txindexfilterfprate option must be at least 0 " +
+			"and less than 1 -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.TxIndexFilterFPRate)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The extra nonce region of the coinbase must be within range.
+	if cfg.CoinbaseExtraNonce < mining.MinCoinbaseExtraNonceSize ||
+		cfg.CoinbaseExtraNonce > mining.MaxCoinbaseExtraNonceSize {
+		str := "%s: the coinbaseextranonce option must be in range " +
+			"[%d, %d] -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, mining.MinCoinbaseExtraNonceSize,
+			mining.MaxCoinbaseExtraNonceSize, cfg.CoinbaseExtraNonce)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Check mining addresses are valid and saved parsed versions.
 	for _, strAddr := range cfg.MiningAddrs {
 		addr, err := address.DecodeAddress(strAddr)
@@ -25,6 +25,10 @@ const (
 	// addrIndexName is the human-readable name for the index.
 	addrIndexName = "address index"
 
+	// addrIndexVersion is the current version of the on-disk format of the
+	// address index.
+	addrIndexVersion = 1
+
 	// level0MaxEntries is the maximum number of transactions that are
 	// stored in level 0 of an address index entry.  Subsequent levels store
 	// 2^n * level0MaxEntries entries, or in words, double the maximum of
@@ -633,11 +637,19 @@ func (idx *AddrIndex) NeedsInputs() bool {
 // initialize for this index.
 //
 // This is part of the Indexer interface.
-func (idx *AddrIndex) Init() error {
+func (idx *AddrIndex) Init(interrupt <-chan struct{}) error {
 	// Nothing to do.
 	return nil
 }
 
+// Tip returns the hash and order of the last block connected to the index.
+//
+// This is part of the Indexer interface.
+func (idx *AddrIndex) Tip(dbTx database.Tx) (*hash.Hash, uint64, error) {
+	h, order, err := dbFetchIndexerTip(dbTx, idx.Key())
+	return h, uint64(order), err
+}
+
 // Key returns the database key to use for the index as a byte slice.
 //
 // This is part of the Indexer interface.
@@ -652,6 +664,13 @@ func (idx *AddrIndex) Name() string {
 	return addrIndexName
 }
 
+// Version returns the version of the on-disk format of the index.
+//
+// This is part of the Indexer interface.
+func (idx *AddrIndex) Version() uint32 {
+	return addrIndexVersion
+}
+
 // Create is invoked when the indexer manager determines the index needs
 // to be created for the first time.  It creates the bucket for the address
 // index.
@@ -749,7 +768,7 @@ func (idx *AddrIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBloc
 
 	// The offset and length of the transactions within the serialized
 	// block.
-	txLocs, err := block.TxLoc()
+	txLocs, err := TxLocations(block)
 	if err != nil {
 		return err
 	}
@@ -843,6 +862,34 @@ func (idx *AddrIndex) TxRegionsForAddress(dbTx database.Tx, addr types.Address,
 	return regions, skipped, err
 }
 
+// TxsForAddress returns the block regions of up to limit confirmed
+// transactions involving the passed address after skipping the first skip of
+// them.  Results are ordered oldest first, or newest first when reverse is set.
+// The regions reference blocks through the block id mapping maintained by the
+// transaction index.
+//
+// Entries are stored in levels of increasing age, so a reverse query only
+// reads as many levels as needed to satisfy skip+limit, which keeps paging
+// through the recent history of busy addresses cheap.
+//
+// This function is safe for concurrent access.
+func (idx *AddrIndex) TxsForAddress(addr types.Address, skip, limit int, reverse bool) ([]*database.BlockRegion, error) {
+	if skip < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid skip %d or limit %d for address "+
+			"query", skip, limit)
+	}
+	regions, _, err := idx.TxRegionsForAddress(nil, addr, uint32(skip),
+		uint32(limit), reverse)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*database.BlockRegion, len(regions))
+	for i := range regions {
+		result[i] = &regions[i]
+	}
+	return result, nil
+}
+
 // indexUnconfirmedAddresses modifies the unconfirmed (memory-only) address
 // index to include mappings for the addresses encoded by the passed public key
 // script to the transaction.
@@ -10,9 +10,11 @@ package index
 import (
 	"encoding/binary"
 	"errors"
+	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
+	"time"
 )
 
 var (
@@ -20,6 +22,12 @@ var (
 	// fields for storage in the database.
 	byteOrder = binary.LittleEndian
 
+	// ByteOrder is the byte order of every numeric field in the on-disk
+	// formats of the indexes, such as block IDs, offsets and lengths.  It
+	// is exposed so external tools can parse the indexes and must not be
+	// modified.
+	ByteOrder binary.ByteOrder = byteOrder
+
 	// errInterruptRequested indicates that an operation was cancelled due
 	// to a user-requested interrupt.
 	errInterruptRequested = errors.New("interrupt requested")
@@ -40,14 +48,25 @@ type Indexer interface {
 	// Name returns the human-readable name of the index.
 	Name() string
 
+	// Version returns the version of the on-disk format of the index.  It
+	// must be increased whenever the format changes, so databases written
+	// with an older format are migrated before the index is used.
+	Version() uint32
+
 	// Create is invoked when the indexer manager determines the index needs
 	// to be created for the first time.
 	Create(dbTx database.Tx) error
 
 	// Init is invoked when the index manager is first initializing the
 	// index.  This differs from the Create method in that it is called on
-	// every load, including the case the index was just created.
-	Init() error
+	// every load, including the case the index was just created.  The
+	// passed channel is closed when initialization should be cancelled.
+	Init(interrupt <-chan struct{}) error
+
+	// Tip returns the hash and order of the last block connected to the
+	// index.  An index without any entries reports the zero hash and an
+	// order of math.MaxUint32.
+	Tip(dbTx database.Tx) (*hash.Hash, uint64, error)
 
 	// ConnectBlock is invoked when the index manager is notified that a new
 	// block has been connected to the main chain.
@@ -58,6 +77,34 @@ type Indexer interface {
 	DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error
 }
 
+// IndexMetrics provides an optional interface for collecting metrics about
+// the operations the index manager performs on its indexes, such as to export
+// them for monitoring.  The name passed to every method is the human-readable
+// name of the index.  The methods are called while the database transaction of
+// the block is open, so they must not block.
+type IndexMetrics interface {
+	// RecordConnect is invoked after an index successfully connected a
+	// block, with the time it took.
+	RecordConnect(name string, d time.Duration)
+
+	// RecordDisconnect is invoked after an index successfully
+	// disconnected a block, with the time it took.
+	RecordDisconnect(name string, d time.Duration)
+
+	// RecordError is invoked when an index fails to connect or disconnect
+	// a block.
+	RecordError(name string, err error)
+}
+
+// IndexMigrator provides a method to migrate an index written with an older
+// version of its on-disk format to the current version returned by Version.
+// The index manager invokes it before initializing the index whenever the
+// stored version is older.  Indexes that do not implement it must be dropped
+// and rebuilt after a format change.
+type IndexMigrator interface {
+	Migrate(oldVersion uint32) error
+}
+
 // IndexDropper provides a method to remove an index from the database. Indexers
 // may implement this for a more efficient way of deleting themselves from the
 // database rather than simply dropping a bucket.
@@ -112,3 +159,11 @@ func interruptRequested(interrupted <-chan struct{}) bool {
 
 	return false
 }
+
+// TxLocations returns the offset and length of every transaction within the
+// serialized passed block, as stored by the transaction and address indexes.
+// The locations are cached by the block, so callers that compute them ahead of
+// connecting the block to the indexes do not pay for a second deserialization.
+func TxLocations(block *types.SerializedBlock) ([]types.TxLoc, error) {
+	return block.TxLoc()
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/database"
+)
+
+// ErrorKind identifies a kind of index error.
+type ErrorKind int
+
+// These constants are used to identify a specific IndexError.
+const (
+	// KindNotFound indicates a requested entry does not exist in the
+	// index.
+	KindNotFound ErrorKind = iota
+
+	// KindCorruption indicates an entry in the index or in a snapshot of
+	// it is malformed.
+	KindCorruption
+
+	// KindNotReady indicates the index can not answer the request yet,
+	// such as before it is initialized, for entries that need a reindex
+	// or for blocks that are not yet deep enough.
+	KindNotReady
+
+	// KindDuplicate indicates a transaction is already indexed for a
+	// different block.
+	KindDuplicate
+)
+
+// Map of ErrorKind values back to their constant names for pretty printing.
+var errorKindStrings = map[ErrorKind]string{
+	KindNotFound:   "KindNotFound",
+	KindCorruption: "KindCorruption",
+	KindNotReady:   "KindNotReady",
+	KindDuplicate:  "KindDuplicate",
+}
+
+// String returns the ErrorKind as a human-readable name.
+func (k ErrorKind) String() string {
+	if s := errorKindStrings[k]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorKind (%d)", int(k))
+}
+
+// IndexError provides a single type for the errors returned by the public API
+// of the indexes.  The caller can use type assertions, or IsIndexError, to
+// determine if an error is an IndexError and access the Kind field to
+// ascertain the specific reason for the failure rather than matching on the
+// error text.
+//
+// The Err field holds the underlying cause when there is one, such as a
+// DuplicateTxError or ErrInsufficientConfirmations.
+type IndexError struct {
+	Kind        ErrorKind // Describes the kind of error
+	Description string    // Human readable description of the issue
+	Err         error     // Underlying error
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e IndexError) Error() string {
+	switch {
+	case e.Description == "" && e.Err != nil:
+		return e.Err.Error()
+	case e.Err != nil:
+		return e.Description + ": " + e.Err.Error()
+	}
+	return e.Description
+}
+
+// Unwrap returns the underlying cause of the error, if any.
+func (e IndexError) Unwrap() error {
+	return e.Err
+}
+
+// makeIndexError creates an IndexError given a set of arguments.
+func makeIndexError(kind ErrorKind, desc string, err error) IndexError {
+	return IndexError{Kind: kind, Description: desc, Err: err}
+}
+
+// IsIndexError returns whether err is an IndexError with a matching kind.
+func IsIndexError(err error, kind ErrorKind) bool {
+	e, ok := err.(IndexError)
+	return ok && e.Kind == kind
+}
+
+// toIndexError converts the errors returned by the database helpers of the
+// indexes to an IndexError of the matching kind.  Errors that are already an
+// IndexError and errors that do not match any kind, such as failures of the
+// underlying database, are returned unchanged.
+func toIndexError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case IndexError:
+		return e
+	case DuplicateTxError:
+		return makeIndexError(KindDuplicate, "", e)
+	case database.Error:
+		switch e.ErrorCode {
+		case database.ErrCorruption:
+			return makeIndexError(KindCorruption, "", e)
+		case database.ErrDbNotOpen:
+			return makeIndexError(KindNotReady, "", e)
+		}
+		return e
+	}
+
+	switch err {
+	case errNoBlockIDEntry, errNoTxHashEntry:
+		return makeIndexError(KindNotFound, "", err)
+	case errNoWitnessLength, ErrInsufficientConfirmations:
+		return makeIndexError(KindNotReady, "", err)
+	}
+	return err
+}
@@ -8,6 +8,7 @@ package index
 import (
 	"sync"
 
+	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
@@ -15,6 +16,12 @@ import (
 	"github.com/Qitmeer/qitmeer/params"
 )
 
+const (
+	// existsAddrIndexVersion is the current version of the on-disk format
+	// of the exists address index.
+	existsAddrIndexVersion = 1
+)
+
 var (
 	// existsAddressIndexName is the human-readable name for the index.
 	existsAddressIndexName = "exists address index"
@@ -79,11 +86,19 @@ var _ Indexer = (*ExistsAddrIndex)(nil)
 // initialize for this index.
 //
 // This is part of the Indexer interface.
-func (idx *ExistsAddrIndex) Init() error {
+func (idx *ExistsAddrIndex) Init(interrupt <-chan struct{}) error {
 	// Nothing to do.
 	return nil
 }
 
+// Tip returns the hash and order of the last block connected to the index.
+//
+// This is part of the Indexer interface.
+func (idx *ExistsAddrIndex) Tip(dbTx database.Tx) (*hash.Hash, uint64, error) {
+	h, order, err := dbFetchIndexerTip(dbTx, idx.Key())
+	return h, uint64(order), err
+}
+
 // Key returns the database key to use for the index as a byte slice.
 //
 // This is part of the Indexer interface.
@@ -98,6 +113,13 @@ func (idx *ExistsAddrIndex) Name() string {
 	return existsAddressIndexName
 }
 
+// Version returns the version of the on-disk format of the index.
+//
+// This is part of the Indexer interface.
+func (idx *ExistsAddrIndex) Version() uint32 {
+	return existsAddrIndexVersion
+}
+
 // Create is invoked when the indexer manager determines the index needs
 // to be created for the first time.  It creates the bucket for the address
 // index.
@@ -14,6 +14,7 @@ import (
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/common/progresslog"
+	"time"
 )
 
 // Manager defines an index manager that manages multiple optional indexes and
@@ -23,6 +24,12 @@ type Manager struct {
 	params         *params.Params
 	db             database.DB
 	enabledIndexes []Indexer
+	metrics        IndexMetrics
+
+	// catchUpBlocks and catchUpInterval limit the rate at which Init
+	// catches up the indexes.  A zero catchUpBlocks means no limit.
+	catchUpBlocks   int
+	catchUpInterval time.Duration
 }
 
 // Ensure the Manager type implements the blockchain.IndexManager interface.
@@ -40,6 +47,37 @@ func NewManager(db database.DB, enabledIndexes []Indexer, params *params.Params)
 	}
 }
 
+// SetMetrics sets the metrics the manager reports the connect and disconnect
+// operations of its indexes to.  Passing nil disables the reporting.  It must
+// be called before the manager is initialized.
+func (m *Manager) SetMetrics(metrics IndexMetrics) {
+	m.metrics = metrics
+}
+
+// SetCatchUpRate limits the catch-up of the indexes to the passed number of
+// blocks per interval, so rebuilding an index does not saturate the disk of a
+// node serving other requests.  Passing zero blocks removes the limit.  It must
+// be called before the manager is initialized.
+func (m *Manager) SetCatchUpRate(blocks int, interval time.Duration) {
+	m.catchUpBlocks = blocks
+	m.catchUpInterval = interval
+}
+
+// waitCatchUpRate blocks until the interval of the catch-up rate that started
+// at the passed time has passed, or until an interrupt is requested.
+func (m *Manager) waitCatchUpRate(start time.Time, interrupt <-chan struct{}) error {
+	wait := m.catchUpInterval - time.Since(start)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-interrupt:
+		return errInterruptRequested
+	case <-time.After(wait):
+		return nil
+	}
+}
+
 // Init initializes the enabled indexes.  This is called during chain
 // initialization and primarily consists of catching up all indexes to the
 // current best chain tip.  This is necessary since each index can be disabled
@@ -47,6 +85,12 @@ func NewManager(db database.DB, enabledIndexes []Indexer, params *params.Params)
 // time new blocks are being downloaded would lead to an overall longer time to
 // catch up due to the I/O contention.
 //
+// The tip of every index is updated in the same database transaction as the
+// entries of each block it indexes, so the tips checkpoint the progress of the
+// catch-up and an interrupted catch-up resumes after the last indexed block
+// rather than starting over.  The catch-up is rate limited when SetCatchUpRate
+// was called.
+//
 // This is part of the blockchain.IndexManager interface.
 func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{}) error {
 	// Nothing to do when no indexes are enabled.
@@ -78,9 +122,14 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 		return err
 	}
 
+	// Migrate indexes written with an older on-disk format.
+	if err := m.maybeMigrateIndexes(); err != nil {
+		return err
+	}
+
 	// Initialize each of the enabled indexes.
 	for _, indexer := range m.enabledIndexes {
-		if err := indexer.Init(); err != nil {
+		if err := indexer.Init(interrupt); err != nil {
 			return err
 		}
 		if indexer.Name() == txIndexName {
@@ -106,17 +155,21 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 
 	// Rollback indexes to the main chain if their tip is an orphaned fork.
 	// This is fairly unlikely, but it can happen if the chain is
-	// reorganized while the index is disabled.  This has to be done in
-	// reverse order because later indexes can depend on earlier ones.
+	// reorganized while the index is disabled or catching up.  The fork
+	// point is the highest order at which the block connected to the index
+	// is still the block the main chain has at that order, so every block
+	// above it is disconnected from the index before the new main chain is
+	// replayed.  This has to be done in reverse order because later indexes
+	// can depend on earlier ones.
 	var spentTxos []blockchain.SpentTxOut
 	for i := len(m.enabledIndexes); i > 0; i-- {
 		indexer := m.enabledIndexes[i-1]
 
 		// Fetch the current tip for the index.
+		var tipHash *hash.Hash
 		var order uint32
 		err := m.db.View(func(dbTx database.Tx) error {
-			idxKey := indexer.Key()
-			_, order, err = dbFetchIndexerTip(dbTx, idxKey)
+			tipHash, order, err = dbFetchIndexerTip(dbTx, indexer.Key())
 			return err
 		})
 		if err != nil {
@@ -127,37 +180,53 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 		if order == math.MaxUint32 {
 			continue
 		}
-		var block *types.SerializedBlock
-		for order > bestOrder {
-			err = m.db.Update(func(dbTx database.Tx) error {
-				// Load the block for the height since it is required to index
-				// it.
-				block, err = blockchain.DBFetchBlockByOrder(dbTx, uint64(order))
+		tipOrder := order
+		for order != math.MaxUint32 && !isMainChainTip(chain, tipHash, order, bestOrder) {
+			// Load the block at the tip of the index by its hash, since
+			// the main chain may have a different block at its order.
+			block, err := chain.FetchBlockByHash(tipHash)
+			if err != nil {
+				return err
+			}
+			block.SetOrder(uint64(order))
+			spentTxos = nil
+			if indexNeedsInputs(indexer) {
+				spentTxos, err = chain.FetchSpendJournal(block)
 				if err != nil {
 					return err
 				}
-				spentTxos = nil
-				if indexNeedsInputs(indexer) {
-					spentTxos, err = chain.FetchSpendJournal(block)
-					if err != nil {
-						return err
-					}
-				}
-				err = m.dbIndexDisconnectBlock(dbTx, indexer, block, spentTxos)
+			}
+
+			prevOrder := order
+			err = m.db.Update(func(dbTx database.Tx) error {
+				err := m.dbIndexDisconnectBlock(dbTx, indexer, block, spentTxos)
 				if err != nil {
 					return err
 				}
 				log.Trace(fmt.Sprintf("%s rollback order= %d", indexer.Name(), order))
-				order--
-				return nil
+				tipHash, order, err = dbFetchIndexerTip(dbTx, indexer.Key())
+				return err
 			})
 			if err != nil {
 				return err
 			}
+			if order == prevOrder {
+				return fmt.Errorf("unable to disconnect block %s at "+
+					"order %d from the %s", block.Hash(), order,
+					indexer.Name())
+			}
 			if interruptRequested(interrupt) {
 				return errInterruptRequested
 			}
 		}
+		if order != tipOrder {
+			forkOrder := int64(order)
+			if order == math.MaxUint32 {
+				forkOrder = -1
+			}
+			log.Info(fmt.Sprintf("Rolled back %s from order %d to fork "+
+				"point %d", indexer.Name(), tipOrder, forkOrder))
+		}
 	}
 
 	// Fetch the current tip heights for each index along with tracking the
@@ -194,7 +263,8 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 
 	// Nothing to index if all of the indexes are caught up.
 	if lowestOrder == int64(bestOrder) {
-		return nil
+		_, err = m.checkTips(chain)
+		return err
 	}
 
 	// Create a progress logger for the indexing process below.
@@ -205,11 +275,23 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 	// each block that needs to be indexed.
 	log.Info(fmt.Sprintf("Catching up indexes from order %d to %d", lowestOrder,
 		bestOrder))
+	if m.catchUpBlocks > 0 {
+		log.Info(fmt.Sprintf("Index catch up is limited to %d blocks "+
+			"every %v", m.catchUpBlocks, m.catchUpInterval))
+	}
 
+	rateStart, rateBlocks := time.Now(), 0
 	for order := lowestOrder + 1; order <= int64(bestOrder); order++ {
 		if interruptRequested(interrupt) {
 			return errInterruptRequested
 		}
+		if m.catchUpBlocks > 0 && rateBlocks >= m.catchUpBlocks {
+			if err := m.waitCatchUpRate(rateStart, interrupt); err != nil {
+				return err
+			}
+			rateStart, rateBlocks = time.Now(), 0
+		}
+		rateBlocks++
 
 		var block *types.SerializedBlock
 		err = m.db.Update(func(dbTx database.Tx) error {
@@ -249,7 +331,7 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 				}
 			}
 			err = m.db.Update(func(dbTx database.Tx) error {
-				return dbIndexConnectBlock(dbTx, indexer, block, spentTxos)
+				return m.dbIndexConnectBlock(dbTx, indexer, block, spentTxos)
 			})
 			if err != nil {
 				return err
@@ -261,6 +343,80 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 	}
 
 	log.Info(fmt.Sprintf("Indexes caught up to order %d", bestOrder))
+	_, err = m.checkTips(chain)
+	return err
+}
+
+// isMainChainTip returns whether the passed index tip, the block with the passed
+// hash connected to the index at the passed order, is still part of the main
+// chain with the passed best order.
+func isMainChainTip(chain *blockchain.BlockChain, tipHash *hash.Hash, order uint32, bestOrder uint32) bool {
+	if order > bestOrder {
+		return false
+	}
+	h := chain.BlockDAG().GetBlockByOrder(uint(order))
+	return h != nil && h.IsEqual(tipHash)
+}
+
+// IndexNames returns the human-readable names of the enabled indexes.
+func (m *Manager) IndexNames() []string {
+	names := make([]string, 0, len(m.enabledIndexes))
+	for _, indexer := range m.enabledIndexes {
+		names = append(names, indexer.Name())
+	}
+	return names
+}
+
+// checkTips compares the tip reported by each enabled index against the best
+// chain and logs any index that lags behind it or whose tip is inconsistent
+// with the stored index tip.  It returns whether any index needs to catch up.
+func (m *Manager) checkTips(chain *blockchain.BlockChain) (bool, error) {
+	bestOrder := uint64(chain.BestSnapshot().GraphState.GetMainOrder())
+	var lagging bool
+	err := m.db.View(func(dbTx database.Tx) error {
+		for _, indexer := range m.enabledIndexes {
+			storedHash, _, err := dbFetchIndexerTip(dbTx, indexer.Key())
+			if err != nil {
+				return err
+			}
+			tipHash, order, err := indexer.Tip(dbTx)
+			if err != nil {
+				return err
+			}
+			if !tipHash.IsEqual(storedHash) {
+				log.Error(fmt.Sprintf("%s tip %s does not match the "+
+					"stored index tip %s", indexer.Name(), tipHash,
+					storedHash))
+				lagging = true
+				continue
+			}
+			if order == math.MaxUint32 || order < bestOrder {
+				orderShow := int64(order)
+				if order == math.MaxUint32 {
+					orderShow = -1
+				}
+				log.Warn(fmt.Sprintf("%s is behind the chain", indexer.Name()),
+					"order", orderShow, "best", bestOrder)
+				lagging = true
+			}
+		}
+		return nil
+	})
+	return lagging, err
+}
+
+// CheckTips verifies that every enabled index is consistent with and caught up
+// to the best chain so queries are not served from a partially synced index.
+// Lagging indexes are logged and, when catchUp is set, brought up to date by
+// rerunning the normal initialization catch-up.
+func (m *Manager) CheckTips(chain *blockchain.BlockChain, catchUp bool, interrupt <-chan struct{}) error {
+	lagging, err := m.checkTips(chain)
+	if err != nil {
+		return err
+	}
+	if lagging && catchUp {
+		return m.Init(chain, interrupt)
+	}
 	return nil
 }
 
@@ -287,6 +443,18 @@ func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
 			}
 		}
 
+		// Let the user know about interrupted drops of indexes that
+		// are not enabled since they are only finished by dropping
+		// them again.
+		for _, index := range knownIndexes {
+			if indexesBucket.Get(indexDropKey(index.key)) == nil ||
+				m.isEnabled(index.key) {
+				continue
+			}
+			log.Warn(fmt.Sprintf("The %s drop was interrupted, drop it "+
+				"again to finish removing it", index.name))
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -304,8 +472,13 @@ func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
 			continue
 		}
 
-		log.Info(fmt.Sprintf("Resuming %s drop", indexer.Name()))
-		err := dropIndex(m.db, indexer.Key(), indexer.Name(), interrupt)
+		remaining, err := countIndexEntries(m.db, indexer.Key())
+		if err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Resuming interrupted %s drop with about %d "+
+			"entries remaining", indexer.Name(), remaining))
+		err = dropIndex(m.db, indexer.Key(), indexer.Name(), interrupt)
 		if err != nil {
 			return err
 		}
@@ -314,6 +487,45 @@ func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
 	return nil
 }
 
+// isEnabled returns whether the index keyed by idxKey is one of the enabled
+// indexes.
+func (m *Manager) isEnabled(idxKey []byte) bool {
+	for _, indexer := range m.enabledIndexes {
+		if bytes.Equal(indexer.Key(), idxKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// countIndexEntries returns the number of keys stored in the bucket of the
+// index keyed by idxKey, including those of any nested buckets.  It is used to
+// report the progress of an interrupted drop.
+func countIndexEntries(db database.DB, idxKey []byte) (uint64, error) {
+	var count uint64
+	var countBucket func(bucket database.Bucket) error
+	countBucket = func(bucket database.Bucket) error {
+		err := bucket.ForEach(func(k, v []byte) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.ForEachBucket(func(k []byte) error {
+			return countBucket(bucket.Bucket(k))
+		})
+	}
+	err := db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(idxKey)
+		if bucket == nil {
+			return nil
+		}
+		return countBucket(bucket)
+	})
+	return count, err
+}
+
 // indexNeedsInputs returns whether or not the index needs access to the txouts
 // referenced by the transaction inputs being indexed.
 func indexNeedsInputs(index Indexer) bool {
@@ -347,11 +559,67 @@ func (m *Manager) maybeCreateIndexes(dbTx database.Tx) error {
 		if err != nil {
 			return err
 		}
+
+		// A new index is written with the current format.
+		err = dbPutIndexerVersion(dbTx, idxKey, indexer.Version())
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// maybeMigrateIndexes compares the stored on-disk format version of each of the
+// enabled indexes with its current version and migrates the indexes that are
+// older.  Indexes created before versions were stored are considered to be at
+// version 1.  An error is returned for an index written by a newer version of
+// the software and for an older index that can not be migrated.
+func (m *Manager) maybeMigrateIndexes() error {
+	for _, indexer := range m.enabledIndexes {
+		var version uint32
+		err := m.db.View(func(dbTx database.Tx) error {
+			var err error
+			version, err = dbFetchIndexerVersion(dbTx, indexer.Key())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case version == indexer.Version():
+			continue
+		case version > indexer.Version():
+			return fmt.Errorf("the %s was written with version %d of "+
+				"its format, which is newer than the supported "+
+				"version %d", indexer.Name(), version,
+				indexer.Version())
+		}
+
+		migrator, ok := indexer.(IndexMigrator)
+		if !ok {
+			return fmt.Errorf("the %s was written with version %d of "+
+				"its format and can not be migrated to version %d, "+
+				"drop it to rebuild it", indexer.Name(), version,
+				indexer.Version())
+		}
+		log.Info(fmt.Sprintf("Migrating %s from version %d to %d",
+			indexer.Name(), version, indexer.Version()))
+		if err := migrator.Migrate(version); err != nil {
+			return err
+		}
+		err = m.db.Update(func(dbTx database.Tx) error {
+			return dbPutIndexerVersion(dbTx, indexer.Key(),
+				indexer.Version())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ConnectBlock must be invoked when a block is extending the main chain.  It
 // keeps track of the state of each index it is managing, performs some sanity
 // checks, and invokes each indexer.
@@ -361,7 +629,7 @@ func (m *Manager) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, s
 	// Call each of the currently active optional indexes with the block
 	// being connected so they can update accordingly.
 	for _, index := range m.enabledIndexes {
-		err := dbIndexConnectBlock(dbTx, index, block, stxos)
+		err := m.dbIndexConnectBlock(dbTx, index, block, stxos)
 		if err != nil {
 			return err
 		}
@@ -481,7 +749,16 @@ func (m *Manager) dbIndexDisconnectBlock(dbTx database.Tx, indexer Indexer, bloc
 	}
 	// Notify the indexer with the disconnected block so it can remove all
 	// of the appropriate entries.
-	if err := indexer.DisconnectBlock(dbTx, block, stxos); err != nil {
+	start := time.Now()
+	err = indexer.DisconnectBlock(dbTx, block, stxos)
+	if m.metrics != nil {
+		if err != nil {
+			m.metrics.RecordError(indexer.Name(), err)
+		} else {
+			m.metrics.RecordDisconnect(indexer.Name(), time.Since(start))
+		}
+	}
+	if err != nil {
 		return err
 	}
 
@@ -506,7 +783,7 @@ func (m *Manager) dbIndexDisconnectBlock(dbTx database.Tx, indexer Indexer, bloc
 // given block using the provided indexer and updates the tip of the indexer
 // accordingly.  An error will be returned if the current tip for the indexer is
 // not the previous block for the passed block.
-func dbIndexConnectBlock(dbTx database.Tx, indexer Indexer, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+func (m *Manager) dbIndexConnectBlock(dbTx database.Tx, indexer Indexer, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
 	// Assert that the block being connected properly connects to the
 	// current tip of the index.
 	idxKey := indexer.Key()
@@ -525,7 +802,16 @@ func dbIndexConnectBlock(dbTx database.Tx, indexer Indexer, block *types.Seriali
 	}
 
 	// Notify the indexer with the connected block so it can index it.
-	if err := indexer.ConnectBlock(dbTx, block, stxos); err != nil {
+	start := time.Now()
+	err = indexer.ConnectBlock(dbTx, block, stxos)
+	if m.metrics != nil {
+		if err != nil {
+			m.metrics.RecordError(indexer.Name(), err)
+		} else {
+			m.metrics.RecordConnect(indexer.Name(), time.Since(start))
+		}
+	}
+	if err != nil {
 		return err
 	}
 
@@ -576,6 +862,13 @@ func dbPutIndexerTip(dbTx database.Tx, idxKey []byte, h *hash.Hash, order uint32
 	return indexesBucket.Put(idxKey, serialized)
 }
 
+// IndexExists returns whether the index keyed by idxKey, as returned by the Key
+// method of its indexer, has been created in the database.  Unlike the drop
+// functions it does not modify the database.
+func IndexExists(db database.DB, idxKey []byte) (bool, error) {
+	return existsIndex(db, idxKey, "")
+}
+
 // existsIndex returns whether the index keyed by idxKey exists in the database.
 func existsIndex(db database.DB, idxKey []byte, idxName string) (bool, error) {
 	var exists bool
@@ -599,6 +892,42 @@ func markIndexDeletion(db database.DB, idxKey []byte) error {
 	})
 }
 
+// indexVersionKey returns the key for the on-disk format version of an index.
+func indexVersionKey(idxKey []byte) []byte {
+	versionKey := make([]byte, len(idxKey)+1)
+	versionKey[0] = 'v'
+	copy(versionKey[1:], idxKey)
+	return versionKey
+}
+
+// dbPutIndexerVersion uses an existing database transaction to store the
+// on-disk format version of the provided index.
+func dbPutIndexerVersion(dbTx database.Tx, idxKey []byte, version uint32) error {
+	var serialized [4]byte
+	byteOrder.PutUint32(serialized[:], version)
+	indexesBucket := dbTx.Metadata().Bucket(dbnamespace.IndexTipsBucketName)
+	return indexesBucket.Put(indexVersionKey(idxKey), serialized[:])
+}
+
+// dbFetchIndexerVersion uses an existing database transaction to retrieve the
+// on-disk format version of the provided index.  Indexes created before the
+// version was stored are at version 1.
+func dbFetchIndexerVersion(dbTx database.Tx, idxKey []byte) (uint32, error) {
+	indexesBucket := dbTx.Metadata().Bucket(dbnamespace.IndexTipsBucketName)
+	serialized := indexesBucket.Get(indexVersionKey(idxKey))
+	if serialized == nil {
+		return 1, nil
+	}
+	if len(serialized) != 4 {
+		return 0, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("unexpected length of %d bytes "+
+				"for index %q version", len(serialized), string(idxKey)),
+		}
+	}
+	return byteOrder.Uint32(serialized), nil
+}
+
 // indexDropKey returns the key for an index which indicates it is in the
 // process of being dropped.
 func indexDropKey(idxKey []byte) []byte {
@@ -726,6 +1055,10 @@ func dropIndexMetadata(db database.DB, idxKey []byte, idxName string) error {
 			return err
 		}
 
+		err = indexesBucket.Delete(indexVersionKey(idxKey))
+		if err != nil {
+			return err
+		}
 		return indexesBucket.Delete(indexDropKey(idxKey))
 	})
 }
@@ -787,3 +1120,182 @@ func dropIndex(db database.DB, idxKey []byte, idxName string, interrupt <-chan s
 	log.Info(fmt.Sprintf("Dropped %s", idxName))
 	return nil
 }
+
+// knownIndexes lists the keys and names of all optional indexes in the order
+// they must be dropped, that is dependent indexes before the ones they rely on.
+var knownIndexes = []struct {
+	key  []byte
+	name string
+}{
+	{existsAddrIndexKey, existsAddressIndexName},
+	{addrIndexKey, addrIndexName},
+	{txIndexKey, txIndexName},
+}
+
+// DropAllIndexes drops every optional index that exists in the provided
+// database.  Indexes are dropped in dependency order, so the address indexes go
+// before the transaction index they rely on.  All of the existing indexes are
+// marked for deletion in a single database update before any of them is
+// dropped, so an interrupted run is finished for every one of them on the next
+// start.
+func DropAllIndexes(db database.DB, interrupt <-chan struct{}) error {
+	var existing [][]byte
+	var existingNames []string
+	for _, index := range knownIndexes {
+		exists, err := existsIndex(db, index.key, index.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			existing = append(existing, index.key)
+			existingNames = append(existingNames, index.name)
+		}
+	}
+	if len(existing) == 0 {
+		log.Info("Not dropping any indexes because none exist")
+		return nil
+	}
+
+	err := db.Update(func(dbTx database.Tx) error {
+		indexesBucket := dbTx.Metadata().Bucket(dbnamespace.IndexTipsBucketName)
+		for _, idxKey := range existing {
+			err := indexesBucket.Put(indexDropKey(idxKey), idxKey)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, idxKey := range existing {
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+		err := dropIndex(db, idxKey, existingNames[i], interrupt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildFrom rebuilds the tail of the passed index starting at the block with
+// the given order.  Every block from the current index tip down to startOrder
+// is disconnected from the index, removing its entries, and the same blocks are
+// then connected again in order.  This allows a corrupted index tail to be
+// repaired without dropping and recreating the entire index.  Any blocks past
+// the original index tip are left for the normal catch-up in Manager.Init.
+//
+// The index is initialized with Init first, so state the index keeps in memory,
+// such as the current block ID of the transaction index, matches the stored
+// tip.  A KindCorruption error is returned when a block fails to move the index
+// tip, which happens when the stored tip doesn't match the block being
+// disconnected or connected.
+func RebuildFrom(db database.DB, idx Indexer, startOrder uint64, interrupt <-chan struct{}) error {
+	if err := idx.Init(interrupt); err != nil {
+		return err
+	}
+
+	var tipOrder uint32
+	err := db.View(func(dbTx database.Tx) error {
+		var err error
+		_, tipOrder, err = dbFetchIndexerTip(dbTx, idx.Key())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nothing to rebuild when the index is empty or does not reach the
+	// requested start.
+	if tipOrder == math.MaxUint32 || startOrder > uint64(tipOrder) {
+		return nil
+	}
+
+	log.Info(fmt.Sprintf("Rebuilding %s from order %d to %d", idx.Name(),
+		startOrder, tipOrder))
+
+	// fetchBlock loads the block with the passed order along with the
+	// spent txouts when the index requires them.
+	fetchBlock := func(dbTx database.Tx, order uint64) (*types.SerializedBlock, []blockchain.SpentTxOut, error) {
+		block, err := blockchain.DBFetchBlockByOrder(dbTx, order)
+		if err != nil {
+			return nil, nil, err
+		}
+		var stxos []blockchain.SpentTxOut
+		if indexNeedsInputs(idx) {
+			stxos, err = blockchain.DBFetchSpendJournalEntry(dbTx, block)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return block, stxos, nil
+	}
+
+	// checkTip ensures the index tip is at the passed order, since
+	// disconnecting or connecting a block that doesn't match the tip only
+	// logs a warning and leaves the index unchanged.
+	checkTip := func(dbTx database.Tx, want uint32) error {
+		_, order, err := dbFetchIndexerTip(dbTx, idx.Key())
+		if err != nil {
+			return err
+		}
+		if order != want {
+			str := fmt.Sprintf("%s tip is at order %d instead of %d "+
+				"while rebuilding", idx.Name(), order, want)
+			return makeIndexError(KindCorruption, str, nil)
+		}
+		return nil
+	}
+
+	m := &Manager{db: db}
+	for order := int64(tipOrder); order >= int64(startOrder); order-- {
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+		err := db.Update(func(dbTx database.Tx) error {
+			block, stxos, err := fetchBlock(dbTx, uint64(order))
+			if err != nil {
+				return err
+			}
+			err = m.dbIndexDisconnectBlock(dbTx, idx, block, stxos)
+			if err != nil {
+				return err
+			}
+			want := uint32(math.MaxUint32)
+			if order > 0 {
+				want = uint32(order - 1)
+			}
+			return checkTip(dbTx, want)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for order := startOrder; order <= uint64(tipOrder); order++ {
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+		err := db.Update(func(dbTx database.Tx) error {
+			block, stxos, err := fetchBlock(dbTx, order)
+			if err != nil {
+				return err
+			}
+			err = m.dbIndexConnectBlock(dbTx, idx, block, stxos)
+			if err != nil {
+				return err
+			}
+			return checkTip(dbTx, uint32(order))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info(fmt.Sprintf("Rebuilt %s up to order %d", idx.Name(), tipOrder))
+	return nil
+}
@@ -34,7 +34,7 @@ func dbAddInvalidTxIndexEntries(dbTx database.Tx, block *types.SerializedBlock,
 		}
 		return nil
 	}
-	txLocs, err := block.TxLoc()
+	txLocs, err := TxLocations(block)
 	if err != nil {
 		return err
 	}
@@ -152,7 +152,7 @@ func (idx *TxIndex) InvalidTxBlockRegion(id hash.Hash) (*database.BlockRegion, e
 		region, err = dbFetchInvalidTxIndexEntry(dbTx, &id)
 		return err
 	})
-	return region, err
+	return region, toIndexError(err)
 }
 
 func (idx *TxIndex) GetInvalidTxIdByHash(txhash hash.Hash) (*hash.Hash, error) {
@@ -166,7 +166,7 @@ func (idx *TxIndex) GetInvalidTxIdByHash(txhash hash.Hash) (*hash.Hash, error) {
 		txid = id
 		return nil
 	})
-	return txid, err
+	return txid, toIndexError(err)
 }
 
 // Because there was a problem with the previous version[1234f -> 4f610] of droptxindex,
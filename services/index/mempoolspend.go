@@ -0,0 +1,73 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"sync"
+)
+
+// MempoolSpender provides a generic interface for an indexer which tracks the
+// spenders of outputs and also wants to reflect spends by transactions that are
+// still in the memory pool.
+type MempoolSpender interface {
+	// AddMempoolSpend records that the passed output is spent by the
+	// unconfirmed transaction with the passed hash.
+	AddMempoolSpend(out types.TxOutPoint, spendingTx hash.Hash)
+
+	// RemoveMempoolSpend forgets the unconfirmed spender of the passed
+	// output, if any.
+	RemoveMempoolSpend(out types.TxOutPoint)
+}
+
+// MempoolSpends is a memory-only set of outputs spent by unconfirmed
+// transactions.  Spend-tracking indexers embed it to implement the
+// MempoolSpender interface and consult it when an output has no confirmed
+// spender.
+type MempoolSpends struct {
+	mtx    sync.RWMutex
+	spends map[types.TxOutPoint]hash.Hash
+}
+
+// Ensure the MempoolSpends type implements the MempoolSpender interface.
+var _ MempoolSpender = (*MempoolSpends)(nil)
+
+// AddMempoolSpend records that the passed output is spent by the unconfirmed
+// transaction with the passed hash.
+//
+// This function is safe for concurrent access.
+func (m *MempoolSpends) AddMempoolSpend(out types.TxOutPoint, spendingTx hash.Hash) {
+	m.mtx.Lock()
+	if m.spends == nil {
+		m.spends = make(map[types.TxOutPoint]hash.Hash)
+	}
+	m.spends[out] = spendingTx
+	m.mtx.Unlock()
+}
+
+// RemoveMempoolSpend forgets the unconfirmed spender of the passed output.
+//
+// This function is safe for concurrent access.
+func (m *MempoolSpends) RemoveMempoolSpend(out types.TxOutPoint) {
+	m.mtx.Lock()
+	delete(m.spends, out)
+	m.mtx.Unlock()
+}
+
+// MempoolSpender returns the hash of the unconfirmed transaction spending the
+// passed output, or nil when no transaction in the memory pool spends it.
+//
+// This function is safe for concurrent access.
+func (m *MempoolSpends) MempoolSpender(out types.TxOutPoint) *hash.Hash {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	spender, ok := m.spends[out]
+	if !ok {
+		return nil
+	}
+	return &spender
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"container/list"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/database"
+	"sync"
+)
+
+// regionCacheEntry is a single memoized transaction block region.
+type regionCacheEntry struct {
+	txid   hash.Hash
+	region database.BlockRegion
+}
+
+// txRegionCache provides a concurrency safe cache of transaction block regions
+// that is limited to a maximum number of items with eviction of the least
+// recently used entry when the limit is exceeded.
+//
+// Entries are invalidated from within the database transaction that changes
+// them, before it is committed, so a reader could otherwise put the old entry
+// back into the cache.  Every invalidation therefore bumps a generation that
+// readers capture before reading the database, and the transactions
+// invalidated by the latest database transaction can't be cached until an
+// invalidation from another one.  The database only allows a single writable
+// transaction at a time, so by then the earlier one has been committed or
+// rolled back.
+type txRegionCache struct {
+	mtx    sync.Mutex
+	items  map[hash.Hash]*list.Element // nearly O(1) lookups
+	lru    *list.List                  // O(1) insert, update, delete
+	limit  int
+	hits   uint64
+	misses uint64
+
+	generation uint64
+	pendingTx  database.Tx
+	pending    map[hash.Hash]struct{}
+}
+
+// newTxRegionCache returns a new cache which holds at most limit regions.
+func newTxRegionCache(limit int) *txRegionCache {
+	return &txRegionCache{
+		items: make(map[hash.Hash]*list.Element),
+		lru:   list.New(),
+		limit: limit,
+	}
+}
+
+// lookup returns a copy of the cached region for the passed transaction hash
+// and marks it as the most recently used item.  Nil is returned on a miss.
+//
+// This function is safe for concurrent access.
+func (c *txRegionCache) lookup(txid *hash.Hash) *database.BlockRegion {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.items[*txid]
+	if !ok {
+		c.misses++
+		return nil
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+
+	region := elem.Value.(*regionCacheEntry).region
+	regionHash := *region.Hash
+	region.Hash = &regionHash
+	return &region
+}
+
+// currentGeneration returns the generation to pass to add for a region that
+// is about to be read from the database.
+//
+// This function is safe for concurrent access.
+func (c *txRegionCache) currentGeneration() uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.generation
+}
+
+// add stores a copy of the passed region for the transaction hash, evicting
+// the least recently used item when the cache is full.  The region is not
+// stored when the cache was invalidated since the passed generation was
+// obtained, or when the transaction may still change in an uncommitted
+// database transaction.
+//
+// This function is safe for concurrent access.
+func (c *txRegionCache) add(txid *hash.Hash, region *database.BlockRegion, generation uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.limit <= 0 || generation != c.generation {
+		return
+	}
+	if _, ok := c.pending[*txid]; ok {
+		return
+	}
+
+	regionHash := *region.Hash
+	entry := &regionCacheEntry{txid: *txid, region: *region}
+	entry.region.Hash = &regionHash
+
+	if elem, ok := c.items[*txid]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	if c.lru.Len() >= c.limit {
+		oldest := c.lru.Back()
+		delete(c.items, oldest.Value.(*regionCacheEntry).txid)
+		c.lru.Remove(oldest)
+	}
+	c.items[*txid] = c.lru.PushFront(entry)
+}
+
+// invalidate evicts the regions for the passed transaction hashes and keeps
+// them from being cached again while the passed database transaction may still
+// be uncommitted.  It must be called with the writable database transaction
+// for every transaction whose entry it is about to change.
+//
+// This function is safe for concurrent access.
+func (c *txRegionCache) invalidate(dbTx database.Tx, txids []*hash.Hash) {
+	c.mtx.Lock()
+	c.generation++
+
+	// A database transaction can change several blocks, so the pending
+	// transactions are only released once another database transaction
+	// invalidates, which means this one has finished.
+	if dbTx != c.pendingTx {
+		c.pendingTx = dbTx
+		c.pending = make(map[hash.Hash]struct{}, len(txids))
+	}
+	for _, txid := range txids {
+		c.pending[*txid] = struct{}{}
+		if elem, ok := c.items[*txid]; ok {
+			delete(c.items, *txid)
+			c.lru.Remove(elem)
+		}
+	}
+	c.mtx.Unlock()
+}
+
+// reset evicts every cached region.
+//
+// This function is safe for concurrent access.
+func (c *txRegionCache) reset() {
+	c.mtx.Lock()
+	c.generation++
+	c.pendingTx = nil
+	c.pending = nil
+	c.items = make(map[hash.Hash]*list.Element)
+	c.lru.Init()
+	c.mtx.Unlock()
+}
+
+// stats returns the number of cache hits and misses so far.
+//
+// This function is safe for concurrent access.
+func (c *txRegionCache) stats() (uint64, uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.hits, c.misses
+}
@@ -0,0 +1,415 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/log"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// -----------------------------------------------------------------------------
+// A transaction index snapshot written by ExportTxIndex starts with a header
+// made up of the magic bytes "QTXI" and a format version byte, followed by a
+// series of frames:
+//
+//   Field      Type      Size
+//   kind       byte      1 byte
+//   length     uint32    4 bytes
+//   payload    []byte    length bytes
+//   checksum   uint32    4 bytes
+//
+// The checksum is the CRC-32 (Castagnoli) of the kind and the payload.  The
+// payload of a frame is a sequence of key/value pairs for the bucket identified
+// by its kind, each encoded as a varint key length, the key, a varint value
+// length and the value.  The last frame has kind txExportFrameEnd and an empty
+// payload, so a snapshot that is cut off at a frame boundary is detected too.
+// -----------------------------------------------------------------------------
+
+const (
+	// txExportVersion is the version of the snapshot format.  Version 2
+	// added the frames of the ID to transactions bucket and version 3 those
+	// of the ID to transaction count bucket, so snapshots of older versions
+	// are still accepted on import.
+	txExportVersion = 3
+
+	// txExportFrameEntries is the number of key/value pairs written per
+	// frame.
+	txExportFrameEntries = 4096
+
+	// maxTxExportFrameSize is the maximum payload size of a frame accepted
+	// on import.
+	maxTxExportFrameSize = 64 * 1024 * 1024
+)
+
+// The following are the kinds of frames in a transaction index snapshot.
+const (
+	txExportFrameEnd byte = iota
+	txExportFrameTx
+	txExportFrameIDByHash
+	txExportFrameHashByID
+	txExportFrameTxidByTxhash
+	txExportFrameTip
+	txExportFrameTxsByBlockID
+	txExportFrameTxCountByBlockID
+)
+
+var (
+	// txExportMagic identifies a transaction index snapshot.
+	txExportMagic = []byte("QTXI")
+
+	// txExportTable is the CRC-32 table used for the frame checksums.
+	txExportTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// txExportBuckets maps the frame kinds holding bucket contents to the
+	// names of those buckets.
+	txExportBuckets = []struct {
+		kind byte
+		name []byte
+	}{
+		{txExportFrameTx, txIndexKey},
+		{txExportFrameIDByHash, idByHashIndexBucketName},
+		{txExportFrameHashByID, hashByIDIndexBucketName},
+		{txExportFrameTxidByTxhash, txidByTxhashBucketName},
+		{txExportFrameTxsByBlockID, txsByBlockIDBucketName},
+		{txExportFrameTxCountByBlockID, txCountByBlockIDBucketName},
+	}
+)
+
+// writeTxExportFrame writes a single frame of the passed kind and payload.
+func writeTxExportFrame(w io.Writer, kind byte, payload []byte) error {
+	var header [5]byte
+	header[0] = kind
+	byteOrder.PutUint32(header[1:], uint32(len(payload)))
+	checksum := crc32.Update(crc32.Checksum(header[:1], txExportTable),
+		txExportTable, payload)
+	var trailer [4]byte
+	byteOrder.PutUint32(trailer[:], checksum)
+
+	for _, b := range [][]byte{header[:], payload, trailer[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putTxExportPair appends the passed key/value pair to a frame payload.
+func putTxExportPair(payload *bytes.Buffer, k, v []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	payload.Write(buf[:binary.PutUvarint(buf[:], uint64(len(k)))])
+	payload.Write(k)
+	payload.Write(buf[:binary.PutUvarint(buf[:], uint64(len(v)))])
+	payload.Write(v)
+}
+
+// ExportTxIndex writes a snapshot of the transaction index, its block ID
+// buckets and its tip to the passed writer in the format described above.  The
+// snapshot is taken from a single database transaction.  The export stops with
+// errInterruptRequested as soon as the interrupt channel is closed.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) ExportTxIndex(w io.Writer, interrupt <-chan struct{}) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(txExportMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(txExportVersion); err != nil {
+		return err
+	}
+
+	err := idx.db.View(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		for _, b := range txExportBuckets {
+			bucket := meta.Bucket(b.name)
+			if bucket == nil {
+				return fmt.Errorf("%s bucket %s does not exist",
+					txIndexName, b.name)
+			}
+
+			var payload bytes.Buffer
+			entries := 0
+			err := bucket.ForEach(func(k, v []byte) error {
+				if interruptRequested(interrupt) {
+					return errInterruptRequested
+				}
+				putTxExportPair(&payload, k, v)
+				entries++
+				if entries < txExportFrameEntries {
+					return nil
+				}
+				entries = 0
+				err := writeTxExportFrame(bw, b.kind, payload.Bytes())
+				payload.Reset()
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if entries > 0 {
+				err := writeTxExportFrame(bw, b.kind, payload.Bytes())
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		tip := meta.Bucket(dbnamespace.IndexTipsBucketName).Get(txIndexKey)
+		if tip != nil {
+			var payload bytes.Buffer
+			putTxExportPair(&payload, txIndexKey, tip)
+			err := writeTxExportFrame(bw, txExportFrameTip, payload.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeTxExportFrame(bw, txExportFrameEnd, nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readTxExportFrame reads a single frame and verifies its checksum.
+func readTxExportFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, makeIndexError(KindCorruption, "truncated "+
+			"snapshot", err)
+	}
+	length := byteOrder.Uint32(header[1:])
+	if length > maxTxExportFrameSize {
+		str := fmt.Sprintf("snapshot frame of %d bytes exceeds the "+
+			"maximum of %d", length, maxTxExportFrameSize)
+		return 0, nil, makeIndexError(KindCorruption, str, nil)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, makeIndexError(KindCorruption, "truncated "+
+			"snapshot frame", err)
+	}
+	var trailer [4]byte
+	if _, err := io.ReadFull(r, trailer[:]); err != nil {
+		return 0, nil, makeIndexError(KindCorruption, "truncated "+
+			"snapshot frame", err)
+	}
+	checksum := crc32.Update(crc32.Checksum(header[:1], txExportTable),
+		txExportTable, payload)
+	if byteOrder.Uint32(trailer[:]) != checksum {
+		return 0, nil, makeIndexError(KindCorruption, "snapshot frame "+
+			"checksum mismatch", nil)
+	}
+	return header[0], payload, nil
+}
+
+// forEachTxExportPair invokes fn with every key/value pair of a frame payload.
+func forEachTxExportPair(payload []byte, fn func(k, v []byte) error) error {
+	readField := func() ([]byte, error) {
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || size > uint64(len(payload)-n) {
+			return nil, makeIndexError(KindCorruption, "malformed "+
+				"snapshot frame", nil)
+		}
+		field := payload[n : n+int(size)]
+		payload = payload[n+int(size):]
+		return field, nil
+	}
+	for len(payload) > 0 {
+		k, err := readField()
+		if err != nil {
+			return err
+		}
+		v, err := readField()
+		if err != nil {
+			return err
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportTxIndex loads a snapshot written by ExportTxIndex into the transaction
+// index, replacing its contents.  The buckets of the index are cleared and its
+// tip reset once the snapshot header has been verified.  Every frame is then
+// verified against its checksum and written in its own database transaction.
+// The transaction hashes per block missing from snapshots older than version 2
+// and the transaction counts missing from those older than version 3 are
+// backfilled from the imported entries and stored blocks.  A malformed
+// snapshot, including a tip frame for any index but the transaction index,
+// results in an IndexError of kind KindCorruption.
+//
+// The index is marked for deletion until the import is done, so an import that
+// fails is rolled back by dropping the index, and one that is interrupted is
+// dropped by the index manager on the next start.  Since the address index is
+// keyed on the block IDs of the transaction index, importing is refused with a
+// KindNotReady error while the address index exists.
+//
+// This function is NOT safe for concurrent access.  It must only be called
+// while no blocks are being connected.
+func (idx *TxIndex) ImportTxIndex(r io.Reader) error {
+	exists, err := existsIndex(idx.db, addrIndexKey, addrIndexName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return makeIndexError(KindNotReady, fmt.Sprintf("the %s must be "+
+			"dropped before importing the %s", addrIndexName,
+			txIndexName), nil)
+	}
+
+	br := bufio.NewReader(r)
+	header := make([]byte, len(txExportMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return makeIndexError(KindCorruption, "truncated snapshot", err)
+	}
+	if !bytes.Equal(header[:len(txExportMagic)], txExportMagic) {
+		return makeIndexError(KindCorruption, fmt.Sprintf("not a %s "+
+			"snapshot", txIndexName), nil)
+	}
+	version := header[len(txExportMagic)]
+	if version < 1 || version > txExportVersion {
+		return makeIndexError(KindCorruption, fmt.Sprintf("unsupported "+
+			"snapshot version %d", version), nil)
+	}
+
+	// Drop the current contents of the index, so entries that are not in
+	// the snapshot do not survive the import, and mark the index for
+	// deletion until the import is done.
+	err = idx.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		for _, b := range txExportBuckets {
+			if meta.Bucket(b.name) != nil {
+				if err := meta.DeleteBucket(b.name); err != nil {
+					return err
+				}
+			}
+			if _, err := meta.CreateBucket(b.name); err != nil {
+				return err
+			}
+		}
+		tips, err := meta.CreateBucketIfNotExists(
+			dbnamespace.IndexTipsBucketName)
+		if err != nil {
+			return err
+		}
+		err = dbPutIndexerTip(dbTx, txIndexKey, &hash.ZeroHash,
+			math.MaxUint32)
+		if err != nil {
+			return err
+		}
+		return tips.Put(indexDropKey(txIndexKey), txIndexKey)
+	})
+	if err != nil {
+		return err
+	}
+	idx.curBlockID = 0
+	if idx.regionCache != nil {
+		idx.regionCache.reset()
+	}
+
+	err = idx.importTxIndexFrames(br, version)
+	if err != nil {
+		log.Warn(fmt.Sprintf("Dropping the %s after a failed import",
+			txIndexName))
+		idx.curBlockID = 0
+		if idx.regionCache != nil {
+			idx.regionCache.reset()
+		}
+		if derr := dropIndex(idx.db, txIndexKey, txIndexName, nil); derr != nil {
+			log.Error(fmt.Sprintf("Unable to drop the %s: %v",
+				txIndexName, derr))
+		}
+		return err
+	}
+	return idx.db.Update(func(dbTx database.Tx) error {
+		tips := dbTx.Metadata().Bucket(dbnamespace.IndexTipsBucketName)
+		return tips.Delete(indexDropKey(txIndexKey))
+	})
+}
+
+// importTxIndexFrames writes the frames of a snapshot following its header to
+// the cleared transaction index, see ImportTxIndex.
+func (idx *TxIndex) importTxIndexFrames(br *bufio.Reader, version byte) error {
+	bucketNames := make(map[byte][]byte, len(txExportBuckets))
+	for _, b := range txExportBuckets {
+		bucketNames[b.kind] = b.name
+	}
+	var maxBlockID uint32
+	for {
+		kind, payload, err := readTxExportFrame(br)
+		if err != nil {
+			return err
+		}
+		if kind == txExportFrameEnd {
+			break
+		}
+
+		name, ok := bucketNames[kind]
+		if kind == txExportFrameTip {
+			name, ok = dbnamespace.IndexTipsBucketName, true
+		}
+		if !ok {
+			return makeIndexError(KindCorruption, fmt.Sprintf("unknown "+
+				"snapshot frame kind %d", kind), nil)
+		}
+		err = idx.db.Update(func(dbTx database.Tx) error {
+			bucket, err := dbTx.Metadata().CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			return forEachTxExportPair(payload, func(k, v []byte) error {
+				if kind == txExportFrameTip && !bytes.Equal(k, txIndexKey) {
+					return makeIndexError(KindCorruption, fmt.Sprintf(
+						"snapshot tip for unexpected index %q", k), nil)
+				}
+				if kind == txExportFrameHashByID && len(k) == 4 {
+					if id := byteOrder.Uint32(k); id > maxBlockID {
+						maxBlockID = id
+					}
+				}
+				return bucket.Put(k, v)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	idx.curBlockID = maxBlockID
+	if idx.regionCache != nil {
+		idx.regionCache.reset()
+	}
+	if version < 2 {
+		if err := idx.backfillBlockTxs(); err != nil {
+			return err
+		}
+	}
+	if version < 3 {
+		if err := idx.backfillTxCounts(); err != nil {
+			return err
+		}
+	}
+	if idx.txFilter != nil {
+		return idx.db.View(func(dbTx database.Tx) error {
+			return idx.txFilter.Build(dbTx, idx.txFilterCapacity(0), nil)
+		})
+	}
+	return nil
+}
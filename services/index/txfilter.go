@@ -0,0 +1,186 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/binary"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/database"
+	"math"
+	"sync"
+)
+
+const (
+	// txFilterTxsPerBlock is the estimated average number of transactions
+	// per block used to size the filter from the number of indexed blocks.
+	txFilterTxsPerBlock = 4
+
+	// txFilterMinItems is the minimum number of transactions the filter is
+	// sized for.
+	txFilterMinItems = 1 << 16
+
+	// DefaultTxFilterFPRate is the default false positive rate of the
+	// transaction existence filter.
+	DefaultTxFilterFPRate = 0.001
+)
+
+// txFilter is a concurrency safe bloom filter over the hashes of the indexed
+// transactions.  A negative answer means the transaction is definitely not
+// indexed, so lookups can skip the database.  Entries are never removed, so
+// disconnected transactions only add false positives.
+type txFilter struct {
+	mtx       sync.RWMutex
+	bits      []uint64
+	numHashes uint32
+	numItems  int
+	capacity  int
+	fpRate    float64
+
+	// built is false until the filter has been populated from the
+	// database.  Until then every lookup is answered with a maybe.
+	built bool
+}
+
+// newTxFilter returns an empty filter using the passed false positive rate.
+// It must be populated with Build before it filters any lookups.
+func newTxFilter(fpRate float64) *txFilter {
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultTxFilterFPRate
+	}
+	return &txFilter{fpRate: fpRate}
+}
+
+// reset sizes the filter for the passed number of items and clears it.
+//
+// This function MUST be called with the filter lock held (for writes).
+func (f *txFilter) reset(capacity int) {
+	if capacity < txFilterMinItems {
+		capacity = txFilterMinItems
+	}
+	ln2 := math.Ln2
+	numBits := math.Ceil(-float64(capacity) * math.Log(f.fpRate) / (ln2 * ln2))
+	numHashes := math.Round(numBits / float64(capacity) * ln2)
+	if numHashes < 1 {
+		numHashes = 1
+	}
+
+	f.bits = make([]uint64, (uint64(numBits)+63)/64)
+	f.numHashes = uint32(numHashes)
+	f.numItems = 0
+	f.capacity = capacity
+}
+
+// positions calls fn with the bit position of every hash function for the
+// passed transaction hash.  Transaction hashes are uniformly distributed, so
+// their leading words are used directly for double hashing.
+//
+// This function MUST be called with the filter lock held (for reads).
+func (f *txFilter) positions(txid *hash.Hash, fn func(pos uint64)) {
+	numBits := uint64(len(f.bits)) * 64
+	h1 := binary.LittleEndian.Uint64(txid[0:8])
+	h2 := binary.LittleEndian.Uint64(txid[8:16]) | 1
+	for i := uint64(0); i < uint64(f.numHashes); i++ {
+		fn((h1 + i*h2) % numBits)
+	}
+}
+
+// add inserts the passed transaction hash.
+//
+// This function MUST be called with the filter lock held (for writes).
+func (f *txFilter) add(txid *hash.Hash) {
+	f.positions(txid, func(pos uint64) {
+		f.bits[pos/64] |= 1 << (pos % 64)
+	})
+	f.numItems++
+}
+
+// Add inserts the passed transaction hashes into a built filter.
+//
+// This function is safe for concurrent access.
+func (f *txFilter) Add(txids []*hash.Hash) {
+	f.mtx.Lock()
+	if f.built {
+		for _, txid := range txids {
+			f.add(txid)
+		}
+	}
+	f.mtx.Unlock()
+}
+
+// MayContain returns false when the passed transaction hash is definitely not
+// in the filter.  It always returns true while the filter is not built.
+//
+// This function is safe for concurrent access.
+func (f *txFilter) MayContain(txid *hash.Hash) bool {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	if !f.built {
+		return true
+	}
+	found := true
+	f.positions(txid, func(pos uint64) {
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// NeedsRebuild returns whether the filter holds more items than it was sized
+// for, and thus no longer provides its target false positive rate.
+//
+// This function is safe for concurrent access.
+func (f *txFilter) NeedsRebuild() bool {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	return f.built && f.numItems > f.capacity
+}
+
+// Build sizes the filter for the passed number of items and populates it with
+// every transaction hash in the transaction index using the passed database
+// transaction.  The new contents are built aside and swapped in at the end, so
+// lookups keep using the previous contents while it runs.  The build stops
+// with errInterruptRequested as soon as the interrupt channel is closed,
+// leaving the filter unchanged.
+//
+// This function is safe for concurrent access.
+func (f *txFilter) Build(dbTx database.Tx, capacity int, interrupt <-chan struct{}) error {
+	nf := &txFilter{fpRate: f.fpRate}
+	nf.reset(capacity)
+	err := dbTx.Metadata().Bucket(txIndexKey).ForEach(func(k, v []byte) error {
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+		if len(k) != hash.HashSize {
+			return nil
+		}
+		var txid hash.Hash
+		copy(txid[:], k)
+		nf.add(&txid)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.mtx.Lock()
+	f.bits = nf.bits
+	f.numHashes = nf.numHashes
+	f.numItems = nf.numItems
+	f.capacity = nf.capacity
+	f.built = true
+	f.mtx.Unlock()
+	return nil
+}
+
+// Items returns the number of transaction hashes added to the filter.
+//
+// This function is safe for concurrent access.
+func (f *txFilter) Items() int {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	return f.numItems
+}
@@ -6,18 +6,29 @@
 package index
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/common/marshal"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/json"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/log"
+	"math"
 )
 
 const (
 	// txIndexName is the human-readable name for the index.
 	txIndexName = "transaction index"
+
+	// txIndexVersion is the current version of the on-disk format of the
+	// transaction index.  Version 2 added the block ID to transaction
+	// count bucket and version 3 the backfill of the ID to transactions
+	// bucket.
+	txIndexVersion = 3
 )
 
 var (
@@ -37,6 +48,18 @@ var (
 	// the tx hash -> tx id.
 	txidByTxhashBucketName = []byte("txidbytxhash")
 
+	// txsByBlockIDBucketName is the name of the db bucket used to house
+	// the block ID -> transaction hashes of the block.
+	txsByBlockIDBucketName = []byte("txsbyblockid")
+
+	// txCountByBlockIDBucketName is the name of the db bucket used to house
+	// the block ID -> number of transactions in the block.
+	txCountByBlockIDBucketName = []byte("txcountbyblockid")
+
+	// blockIDRebuildKeyName is the metadata key that holds the next order
+	// to write while RebuildBlockIDIndex is in progress.
+	blockIDRebuildKeyName = []byte("txblockidrebuild")
+
 	// errNoBlockIDEntry is an error that indicates a requested entry does
 	// not exist in the block ID index.
 	errNoBlockIDEntry = errors.New("no entry in the block ID index")
@@ -44,6 +67,56 @@ var (
 	// errNoTxHashEntry is an error that indicates a requested entry does
 	// not exist in the tx hash
 	errNoTxHashEntry = errors.New("no entry in the tx hash")
+
+	// errNoWitnessLength is an error that indicates a transaction index
+	// entry predates the witness length field.
+	errNoWitnessLength = errors.New("transaction index entry has no " +
+		"witness length, reindex to add it")
+
+	// ErrInsufficientConfirmations is returned by TxBlockRegionMinConf when
+	// the transaction is indexed but its block does not yet have the
+	// requested number of confirmations.
+	ErrInsufficientConfirmations = errors.New("transaction has " +
+		"insufficient confirmations")
+)
+
+// DuplicateTxError is returned by a strict transaction index when a block
+// contains a transaction whose hash is already indexed for a different block.
+type DuplicateTxError struct {
+	TxHash     hash.Hash
+	OldBlockID uint32
+	NewBlockID uint32
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e DuplicateTxError) Error() string {
+	return fmt.Sprintf("transaction %s is already indexed in block id %d, "+
+		"refusing to overwrite it from block id %d", e.TxHash, e.OldBlockID,
+		e.NewBlockID)
+}
+
+// txIndexEntrySize is the size of a transaction index entry, a regular tx entry
+// followed by the witness length.
+const txIndexEntrySize = txEntrySize + 4
+
+const (
+	// txStaleEntryFlag is the trailing flag byte which marks a transaction
+	// index entry as belonging to a block disconnected from the main chain.
+	txStaleEntryFlag = 0x01
+
+	// txStaleIndexEntrySize is the size of a stale transaction index entry,
+	// the block hash followed by the start offset, tx length, witness
+	// length and flag byte.
+	txStaleIndexEntrySize = hash.HashSize + txIndexEntrySize - 4 + 1
+
+	// txCompactEntryVersion is the trailing format version byte of a
+	// transaction index entry which stores its block ID as a varint.
+	txCompactEntryVersion = 0x02
+
+	// maxTxCompactEntrySize is the maximum size of a compact transaction
+	// index entry, a varint block ID followed by the start offset, tx
+	// length, witness length and version byte.
+	maxTxCompactEntrySize = binary.MaxVarintLen32 + txIndexEntrySize - 4 + 1
 )
 
 // -----------------------------------------------------------------------------
@@ -66,7 +139,10 @@ var (
 // would add a non-trivial amount of space and overhead for something that will
 // realistically never happen per the probability and even if it did, the old
 // one must be fully spent and so the most likely transaction a caller would
-// want for a given hash is the most recent one anyways.
+// want for a given hash is the most recent one anyways.  A strict index created
+// with the Strict option instead refuses to overwrite the entry with one for a
+// different block and fails with an IndexError of kind KindDuplicate wrapping
+// a DuplicateTxError.
 //
 // The serialized format for keys and values in the block hash to ID bucket is:
 //   <hash> = <ID>
@@ -86,17 +162,75 @@ var (
 //   -----
 //   Total: 36 bytes
 //
+// The serialized format for keys and values in the ID to transactions bucket
+// is:
+//   <ID> = <txhash>...
+//
+//   Field           Type              Size
+//   ID              uint32            4 bytes
+//   txhash          hash.Hash    32 bytes each
+//   -----
+//   Total: 4 + 32 * number of transactions bytes
+//
+// The transaction hashes are in the order of the transactions in the block.
+// Blocks connected before the bucket was added have no entry in it.
+//
 // The serialized format for the keys and values in the tx index bucket is:
 //
-//   <txhash> = <block id><start offset><tx length>
+//   <txhash> = <block id><start offset><tx length><witness length>
 //
 //   Field           Type              Size
 //   txhash          hash.Hash    32 bytes
 //   block id        uint32            4 bytes
 //   start offset    uint32            4 bytes
 //   tx length       uint32            4 bytes
+//   witness length  uint32            4 bytes
+//   -----
+//   Total: 48 bytes
+//
+// The witness length is the number of trailing bytes of the transaction, the
+// timestamp and the witness, which are not part of its no-witness
+// serialization.  Entries written before it was added are only 44 bytes long.
+//
+// An index created with the SideChain option keeps the entries of the
+// transactions in a block disconnected from the main chain.  Since block IDs
+// are reused once a block is disconnected, such a stale entry refers to its
+// block by hash and ends with a flag byte:
+//
+//   <txhash> = <block hash><start offset><tx length><witness length><flag>
+//
+//   Field           Type              Size
+//   txhash          hash.Hash    32 bytes
+//   block hash      hash.Hash    32 bytes
+//   start offset    uint32            4 bytes
+//   tx length       uint32            4 bytes
+//   witness length  uint32            4 bytes
+//   flag            byte              1 byte
+//   -----
+//   Total: 77 bytes
+//
+// Stale entries are ignored by all lookups except TxBlockRegionIncludingStale
+// and are replaced by a regular entry if the block is connected again.
+//
+// An index created with the Compact option stores the block ID as a varint,
+// which only takes a single byte for the first 128 blocks, and ends the entry
+// with a format version byte:
+//
+//   <txhash> = <block id><start offset><tx length><witness length><version>
+//
+//   Field           Type              Size
+//   txhash          hash.Hash    32 bytes
+//   block id        uvarint           1-5 bytes
+//   start offset    uint32            4 bytes
+//   tx length       uint32            4 bytes
+//   witness length  uint32            4 bytes
+//   version         byte              1 byte
 //   -----
-//   Total: 44 bytes
+//   Total: 46-50 bytes
+//
+// The last byte of a fixed size entry is the most significant byte of a tx or
+// witness length, which is always zero, so the non-zero version byte tells
+// compact entries apart and both formats can coexist in the same index.
 // -----------------------------------------------------------------------------
 
 // dbPutBlockIDIndexEntry uses an existing database transaction to update or add
@@ -138,6 +272,148 @@ func dbRemoveBlockIDIndexEntry(dbTx database.Tx, hash *hash.Hash) error {
 	return idIndex.Delete(serializedID)
 }
 
+// dbPutBlockTxs uses an existing database transaction to add the hashes of the
+// transactions in the passed block to the ID to transactions index.  Nothing is
+// written when the bucket does not exist.
+func dbPutBlockTxs(dbTx database.Tx, id uint32, block *types.SerializedBlock) error {
+	txsIndex := dbTx.Metadata().Bucket(txsByBlockIDBucketName)
+	if txsIndex == nil {
+		return nil
+	}
+	txns := block.Transactions()
+	serializedTxs := make([]byte, 0, len(txns)*hash.HashSize)
+	for _, tx := range txns {
+		serializedTxs = append(serializedTxs, tx.Hash()[:]...)
+	}
+	var serializedID [4]byte
+	byteOrder.PutUint32(serializedID[:], id)
+	return txsIndex.Put(serializedID[:], serializedTxs)
+}
+
+// dbRemoveBlockTxs uses an existing database transaction to remove the
+// transactions of the block with the provided id from the ID to transactions
+// index.
+func dbRemoveBlockTxs(dbTx database.Tx, id uint32) error {
+	txsIndex := dbTx.Metadata().Bucket(txsByBlockIDBucketName)
+	if txsIndex == nil {
+		return nil
+	}
+	var serializedID [4]byte
+	byteOrder.PutUint32(serializedID[:], id)
+	return txsIndex.Delete(serializedID[:])
+}
+
+// dbPutBlockTxCount uses an existing database transaction to add the number of
+// transactions in the block with the provided id to the ID to transaction count
+// index.  Nothing is written when the bucket does not exist.
+func dbPutBlockTxCount(dbTx database.Tx, id uint32, count uint32) error {
+	countIndex := dbTx.Metadata().Bucket(txCountByBlockIDBucketName)
+	if countIndex == nil {
+		return nil
+	}
+	var serializedID, serializedCount [4]byte
+	byteOrder.PutUint32(serializedID[:], id)
+	byteOrder.PutUint32(serializedCount[:], count)
+	return countIndex.Put(serializedID[:], serializedCount[:])
+}
+
+// dbRemoveBlockTxCount uses an existing database transaction to remove the
+// transaction count of the block with the provided id from the ID to
+// transaction count index.
+func dbRemoveBlockTxCount(dbTx database.Tx, id uint32) error {
+	countIndex := dbTx.Metadata().Bucket(txCountByBlockIDBucketName)
+	if countIndex == nil {
+		return nil
+	}
+	var serializedID [4]byte
+	byteOrder.PutUint32(serializedID[:], id)
+	return countIndex.Delete(serializedID[:])
+}
+
+// dbFetchBlockTxCount uses an existing database transaction to retrieve the
+// number of transactions in the block with the provided id.  The returned flag
+// is false when there is no entry for the provided id.
+func dbFetchBlockTxCount(dbTx database.Tx, id uint32) (uint32, bool, error) {
+	countIndex := dbTx.Metadata().Bucket(txCountByBlockIDBucketName)
+	if countIndex == nil {
+		return 0, false, nil
+	}
+	var serializedID [4]byte
+	byteOrder.PutUint32(serializedID[:], id)
+	serializedCount := countIndex.Get(serializedID[:])
+	if serializedCount == nil {
+		return 0, false, nil
+	}
+	if len(serializedCount) != 4 {
+		return 0, false, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt transaction count entry "+
+				"for block id %d: %d bytes", id, len(serializedCount)),
+		}
+	}
+	return byteOrder.Uint32(serializedCount), true, nil
+}
+
+// dbCountBlockTxs uses an existing database transaction to count the
+// transactions in the block with the provided id and hash.  The count is taken
+// from the ID to transactions index when the block has an entry there and from
+// the stored block otherwise.
+func dbCountBlockTxs(dbTx database.Tx, id uint32, blockHash *hash.Hash) (uint32, error) {
+	serializedTxs, err := dbFetchSerializedBlockTxs(dbTx, id)
+	if err != nil {
+		return 0, err
+	}
+	if serializedTxs != nil {
+		return uint32(len(serializedTxs) / hash.HashSize), nil
+	}
+	block, err := dbFetchBlock(dbTx, blockHash)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(len(block.Transactions())), nil
+}
+
+// dbFetchSerializedBlockTxs uses an existing database transaction to retrieve
+// the serialized ID to transactions entry of the block with the provided id.
+// When there is no entry for the provided id, nil will be returned for the both
+// the entry and the error.
+func dbFetchSerializedBlockTxs(dbTx database.Tx, id uint32) ([]byte, error) {
+	txsIndex := dbTx.Metadata().Bucket(txsByBlockIDBucketName)
+	if txsIndex == nil {
+		return nil, nil
+	}
+	var serializedID [4]byte
+	byteOrder.PutUint32(serializedID[:], id)
+	serializedTxs := txsIndex.Get(serializedID[:])
+	if serializedTxs == nil {
+		return nil, nil
+	}
+	if len(serializedTxs)%hash.HashSize != 0 {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt transactions entry "+
+				"for block id %d: %d bytes", id, len(serializedTxs)),
+		}
+	}
+	return serializedTxs, nil
+}
+
+// dbFetchBlockTxs uses an existing database transaction to retrieve the hashes
+// of the transactions in the block with the provided id.  When there is no
+// entry for the provided id, nil will be returned for the both the hashes and
+// the error.
+func dbFetchBlockTxs(dbTx database.Tx, id uint32) ([]hash.Hash, error) {
+	serializedTxs, err := dbFetchSerializedBlockTxs(dbTx, id)
+	if serializedTxs == nil {
+		return nil, err
+	}
+	txs := make([]hash.Hash, len(serializedTxs)/hash.HashSize)
+	for i := range txs {
+		copy(txs[i][:], serializedTxs[i*hash.HashSize:])
+	}
+	return txs, nil
+}
+
 // dbFetchBlockIDByHash uses an existing database transaction to retrieve the
 // block id for the provided hash from the index.
 func dbFetchBlockIDByHash(dbTx database.Tx, hash *hash.Hash) (uint32, error) {
@@ -182,6 +458,84 @@ func putTxIndexEntry(target []byte, blockID uint32, txLoc types.TxLoc) {
 	byteOrder.PutUint32(target[8:], uint32(txLoc.TxLen))
 }
 
+// TxIndexEntry is the decoded value of an entry in the transaction index
+// bucket, that is the location of a transaction within the block with the
+// given internal block ID.
+type TxIndexEntry struct {
+	BlockID    uint32
+	Offset     uint32
+	Len        uint32
+	WitnessLen uint32
+}
+
+// SerializeTxIndexEntry returns the on-disk serialization of the passed
+// transaction index entry as described at the top of this file.
+func SerializeTxIndexEntry(entry *TxIndexEntry) []byte {
+	serialized := make([]byte, txIndexEntrySize)
+	ByteOrder.PutUint32(serialized[0:4], entry.BlockID)
+	ByteOrder.PutUint32(serialized[4:8], entry.Offset)
+	ByteOrder.PutUint32(serialized[8:12], entry.Len)
+	ByteOrder.PutUint32(serialized[12:16], entry.WitnessLen)
+	return serialized
+}
+
+// putCompactTxIndexEntry serializes the passed values as a compact transaction
+// index entry as described at the top of this file and returns its size.  The
+// target byte slice must be at least maxTxCompactEntrySize bytes or it will
+// panic.
+func putCompactTxIndexEntry(target []byte, blockID uint32, txLoc types.TxLoc, witnessLen uint32) int {
+	n := binary.PutUvarint(target, uint64(blockID))
+	byteOrder.PutUint32(target[n:], uint32(txLoc.TxStart))
+	byteOrder.PutUint32(target[n+4:], uint32(txLoc.TxLen))
+	byteOrder.PutUint32(target[n+8:], witnessLen)
+	target[n+12] = txCompactEntryVersion
+	return n + 13
+}
+
+// isCompactTxIndexEntry returns whether the passed serialized transaction index
+// entry is a compact entry with a varint block ID.
+func isCompactTxIndexEntry(serialized []byte) bool {
+	return len(serialized) > txEntrySize &&
+		len(serialized) <= maxTxCompactEntrySize &&
+		serialized[len(serialized)-1] == txCompactEntryVersion
+}
+
+// DeserializeTxIndexEntry decodes a transaction index entry value as stored
+// on disk, in either the fixed size or the compact format.  Entries written
+// before the witness length was added are accepted and have a zero WitnessLen.
+// A malformed entry results in an IndexError of kind KindCorruption.
+func DeserializeTxIndexEntry(serialized []byte) (*TxIndexEntry, error) {
+	if isCompactTxIndexEntry(serialized) {
+		blockID, n := binary.Uvarint(serialized)
+		if n <= 0 || blockID > math.MaxUint32 ||
+			len(serialized) != n+txIndexEntrySize-4+1 {
+			return nil, makeIndexError(KindCorruption, "malformed "+
+				"compact transaction index entry", nil)
+		}
+		return &TxIndexEntry{
+			BlockID:    uint32(blockID),
+			Offset:     ByteOrder.Uint32(serialized[n:]),
+			Len:        ByteOrder.Uint32(serialized[n+4:]),
+			WitnessLen: ByteOrder.Uint32(serialized[n+8:]),
+		}, nil
+	}
+	if len(serialized) != txEntrySize && len(serialized) != txIndexEntrySize {
+		str := fmt.Sprintf("transaction index entry is %d bytes, "+
+			"expected %d or %d", len(serialized), txEntrySize,
+			txIndexEntrySize)
+		return nil, makeIndexError(KindCorruption, str, nil)
+	}
+	entry := &TxIndexEntry{
+		BlockID: ByteOrder.Uint32(serialized[0:4]),
+		Offset:  ByteOrder.Uint32(serialized[4:8]),
+		Len:     ByteOrder.Uint32(serialized[8:12]),
+	}
+	if len(serialized) == txIndexEntrySize {
+		entry.WitnessLen = ByteOrder.Uint32(serialized[12:16])
+	}
+	return entry, nil
+}
+
 // dbPutTxIndexEntry uses an existing database transaction to update the
 // transaction index given the provided serialized data that is expected to have
 // been serialized putTxIndexEntry.
@@ -190,6 +544,33 @@ func dbPutTxIndexEntry(dbTx database.Tx, txHash *hash.Hash, serializedData []byt
 	return txIndex.Put(txHash[:], serializedData)
 }
 
+// dbCheckDuplicateTxIndexEntry uses an existing database transaction to ensure
+// the provided transaction hash is not already indexed for a block other than
+// the one with the provided block id.  A KindCorruption error is returned when
+// the existing entry can't be decoded.
+func dbCheckDuplicateTxIndexEntry(dbTx database.Tx, txHash *hash.Hash, blockID uint32) error {
+	txIndex := dbTx.Metadata().Bucket(txIndexKey)
+	serializedData := txIndex.Get(txHash[:])
+	if len(serializedData) == 0 || isStaleTxIndexEntry(serializedData) {
+		return nil
+	}
+	entry, err := DeserializeTxIndexEntry(serializedData)
+	if err != nil {
+		str := fmt.Sprintf("corrupt transaction index entry for %s",
+			txHash)
+		return makeIndexError(KindCorruption, str, err)
+	}
+	oldBlockID := entry.BlockID
+	if oldBlockID == blockID {
+		return nil
+	}
+	return makeIndexError(KindDuplicate, "", DuplicateTxError{
+		TxHash:     *txHash,
+		OldBlockID: oldBlockID,
+		NewBlockID: blockID,
+	})
+}
+
 // dbFetchTxIndexEntry uses an existing database transaction to fetch the block
 // region for the provided transaction hash from the transaction index.  When
 // there is no entry for the provided hash, nil will be returned for the both
@@ -198,21 +579,22 @@ func dbFetchTxIndexEntry(dbTx database.Tx, txid *hash.Hash) (*database.BlockRegi
 	// Load the record from the database and return now if it doesn't exist.
 	txIndex := dbTx.Metadata().Bucket(txIndexKey)
 	serializedData := txIndex.Get(txid[:])
-	if len(serializedData) == 0 {
+	if len(serializedData) == 0 || isStaleTxIndexEntry(serializedData) {
 		return nil, nil
 	}
 
-	// Ensure the serialized data has enough bytes to properly deserialize.
-	if len(serializedData) < 12 {
+	// Decode the entry in whichever format it was stored.
+	entry, err := DeserializeTxIndexEntry(serializedData)
+	if err != nil {
 		return nil, database.Error{
 			ErrorCode: database.ErrCorruption,
 			Description: fmt.Sprintf("corrupt transaction index "+
-				"entry for %s", txid),
+				"entry for %s: %v", txid, err),
 		}
 	}
 
 	// Load the block hash associated with the block ID.
-	h, err := dbFetchBlockHashBySerializedID(dbTx, serializedData[0:4])
+	h, err := dbFetchBlockHashByID(dbTx, entry.BlockID)
 	if err != nil {
 		return nil, database.Error{
 			ErrorCode: database.ErrCorruption,
@@ -224,30 +606,110 @@ func dbFetchTxIndexEntry(dbTx database.Tx, txid *hash.Hash) (*database.BlockRegi
 	// Deserialize the final entry.
 	region := database.BlockRegion{Hash: &hash.Hash{}}
 	copy(region.Hash[:], h[:])
-	region.Offset = byteOrder.Uint32(serializedData[4:8])
-	region.Len = byteOrder.Uint32(serializedData[8:12])
+	region.Offset = entry.Offset
+	region.Len = entry.Len
+
+	return &region, nil
+}
 
+// isStaleTxIndexEntry returns whether the passed serialized transaction index
+// entry is a stale entry for a block disconnected from the main chain.
+func isStaleTxIndexEntry(serializedData []byte) bool {
+	return len(serializedData) == txStaleIndexEntrySize &&
+		serializedData[txStaleIndexEntrySize-1] == txStaleEntryFlag
+}
+
+// dbFetchStaleTxIndexEntry uses an existing database transaction to fetch the
+// block region for the provided transaction hash from a stale transaction
+// index entry.  When there is no stale entry for the provided hash, nil will be
+// returned for the both the region and the error.
+func dbFetchStaleTxIndexEntry(dbTx database.Tx, txid *hash.Hash) (*database.BlockRegion, error) {
+	txIndex := dbTx.Metadata().Bucket(txIndexKey)
+	serializedData := txIndex.Get(txid[:])
+	if !isStaleTxIndexEntry(serializedData) {
+		return nil, nil
+	}
+
+	region := database.BlockRegion{Hash: &hash.Hash{}}
+	copy(region.Hash[:], serializedData[:hash.HashSize])
+	region.Offset = byteOrder.Uint32(serializedData[hash.HashSize:])
+	region.Len = byteOrder.Uint32(serializedData[hash.HashSize+4:])
 	return &region, nil
 }
 
+// dbMarkTxIndexEntriesStale uses an existing database transaction to replace
+// the transaction index entry of every transaction in the passed block that is
+// still indexed for it with a stale entry as described at the top of this file.
+func dbMarkTxIndexEntriesStale(dbTx database.Tx, block *types.SerializedBlock) error {
+	txIndex := dbTx.Metadata().Bucket(txIndexKey)
+	for _, tx := range block.Transactions() {
+		region, err := dbFetchTxIndexEntry(dbTx, tx.Hash())
+		if err != nil {
+			return err
+		}
+		if region == nil || !region.Hash.IsEqual(block.Hash()) {
+			continue
+		}
+
+		var witnessLen uint32
+		entry, err := DeserializeTxIndexEntry(txIndex.Get(tx.Hash()[:]))
+		if err == nil {
+			witnessLen = entry.WitnessLen
+		}
+		staleEntry := make([]byte, txStaleIndexEntrySize)
+		copy(staleEntry, block.Hash()[:])
+		byteOrder.PutUint32(staleEntry[hash.HashSize:], region.Offset)
+		byteOrder.PutUint32(staleEntry[hash.HashSize+4:], region.Len)
+		byteOrder.PutUint32(staleEntry[hash.HashSize+8:], witnessLen)
+		staleEntry[txStaleIndexEntrySize-1] = txStaleEntryFlag
+		if err := dbPutTxIndexEntry(dbTx, tx.Hash(), staleEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // dbAddTxIndexEntries uses an existing database transaction to add a
 // transaction index entry for every transaction in the parent of the passed
-// block (if they were valid).
-func dbAddTxIndexEntries(dbTx database.Tx, block *types.SerializedBlock, blockID uint32) error {
+// block (if they were valid).  When strict is set, an existing entry for one of
+// the transactions that belongs to a different block results in an IndexError
+// of kind KindDuplicate instead of being overwritten.  When compact is set, the
+// entries are written in the compact format with a varint block ID.
+func dbAddTxIndexEntries(dbTx database.Tx, block *types.SerializedBlock, blockID uint32, strict bool, compact bool) error {
 	// As an optimization, allocate a single slice big enough to hold all
 	// of the serialized transaction index entries for the block and
 	// serialize them directly into the slice.  Then, pass the appropriate
 	// subslice to the database to be written.  This approach significantly
 	// cuts down on the number of required allocations.
 	addEntries := func(txns []*types.Tx, txLocs []types.TxLoc, blockID uint32) error {
+		entrySize := txIndexEntrySize
+		if compact {
+			entrySize = maxTxCompactEntrySize
+		}
 		offset := 0
-		serializedValues := make([]byte, len(txns)*txEntrySize)
+		serializedValues := make([]byte, len(txns)*entrySize)
 		for i, tx := range txns {
-			putTxIndexEntry(serializedValues[offset:], blockID,
-				txLocs[i])
-			endOffset := offset + txEntrySize
+			witnessLen := txLocs[i].TxLen - tx.Tx.SerializeSizeNoWitness()
+			endOffset := offset + txIndexEntrySize
+			if compact {
+				endOffset = offset + putCompactTxIndexEntry(
+					serializedValues[offset:], blockID, txLocs[i],
+					uint32(witnessLen))
+			} else {
+				putTxIndexEntry(serializedValues[offset:], blockID,
+					txLocs[i])
+				byteOrder.PutUint32(serializedValues[offset+txEntrySize:],
+					uint32(witnessLen))
+			}
 
 			if !tx.IsDuplicate {
+				if strict {
+					err := dbCheckDuplicateTxIndexEntry(dbTx,
+						tx.Hash(), blockID)
+					if err != nil {
+						return err
+					}
+				}
 				if err := dbPutTxIndexEntry(dbTx, tx.Hash(),
 					serializedValues[offset:endOffset:endOffset]); err != nil {
 					return err
@@ -256,7 +718,7 @@ func dbAddTxIndexEntries(dbTx database.Tx, block *types.SerializedBlock, blockID
 					return err
 				}
 			}
-			offset += txEntrySize
+			offset = endOffset
 		}
 		return nil
 	}
@@ -264,7 +726,7 @@ func dbAddTxIndexEntries(dbTx database.Tx, block *types.SerializedBlock, blockID
 	// Add the regular transactions.
 	// The offset and length of the transactions within the
 	// serialized parent block.
-	txLocs, err := block.TxLoc()
+	txLocs, err := TxLocations(block)
 	if err != nil {
 		return err
 	}
@@ -351,6 +813,26 @@ type TxIndex struct {
 	curBlockID uint32
 
 	chain *blockchain.BlockChain
+
+	// regionCache memoizes recent TxBlockRegion results.  It is nil when
+	// the index was created without a cache.
+	regionCache *txRegionCache
+
+	// strict makes connecting a block fail rather than overwrite the entry
+	// of a transaction hash already indexed for another block.
+	strict bool
+
+	// sideChain keeps the entries of disconnected blocks as stale entries
+	// instead of removing them.
+	sideChain bool
+
+	// compact stores the block ID of new entries as a varint.
+	compact bool
+
+	// txFilter answers TxBlockRegion lookups for transactions that are
+	// definitely not indexed without a database read.  It is nil when the
+	// index was created without a filter.
+	txFilter *txFilter
 }
 
 // Ensure the TxIndex type implements the Indexer interface.
@@ -358,15 +840,33 @@ var _ Indexer = (*TxIndex)(nil)
 
 // Init initializes the hash-based transaction index.  In particular, it finds
 // the highest used block ID and stores it for later use when connecting or
-// disconnecting blocks.
+// disconnecting blocks.  The search stops with errInterruptRequested as soon as
+// the interrupt channel is closed.
 //
 // This is part of the Indexer interface.
-func (idx *TxIndex) Init() error {
+func (idx *TxIndex) Init(interrupt <-chan struct{}) error {
+	// The ID to transactions bucket of indexes created before it was added
+	// is created and backfilled by Migrate.  The block ID mappings are
+	// incomplete while a rebuild of them is unfinished.
+	err := idx.db.Update(func(dbTx database.Tx) error {
+		if dbTx.Metadata().Get(blockIDRebuildKeyName) != nil {
+			return makeIndexError(KindNotReady, "the block ID index "+
+				"rebuild is unfinished, run it again to resume it",
+				nil)
+		}
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(
+			txsByBlockIDBucketName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
 	// Find the latest known block id field for the internal block id
 	// index and initialize it.  This is done because it's a lot more
 	// efficient to do a single search at initialize time than it is to
 	// write another value to the database on every update.
-	err := idx.db.View(func(dbTx database.Tx) error {
+	err = idx.db.View(func(dbTx database.Tx) error {
 		// Scan forward in large gaps to find a block id that doesn't
 		// exist yet to serve as an upper bound for the binary search
 		// below.
@@ -374,6 +874,10 @@ func (idx *TxIndex) Init() error {
 		testBlockID := uint32(1)
 		increment := uint32(100000)
 		for {
+			if interruptRequested(interrupt) {
+				return errInterruptRequested
+			}
+
 			_, err := dbFetchBlockHashByID(dbTx, testBlockID)
 			if err != nil {
 				nextUnknown = testBlockID
@@ -394,6 +898,10 @@ func (idx *TxIndex) Init() error {
 		// Use a binary search to find the final highest used block id.
 		// This will take at most ceil(log_2(increment)) attempts.
 		for {
+			if interruptRequested(interrupt) {
+				return errInterruptRequested
+			}
+
 			testBlockID = (highestKnown + nextUnknown) / 2
 			_, err := dbFetchBlockHashByID(dbTx, testBlockID)
 			if err != nil {
@@ -415,10 +923,42 @@ func (idx *TxIndex) Init() error {
 		return err
 	}
 
+	if idx.txFilter != nil {
+		err = idx.db.View(func(dbTx database.Tx) error {
+			return idx.txFilter.Build(dbTx, idx.txFilterCapacity(0),
+				interrupt)
+		})
+		if err != nil {
+			return err
+		}
+		log.Debug("Built transaction filter", "txs", idx.txFilter.Items())
+	}
+
 	log.Debug("Current internal block ", "block id", idx.curBlockID)
 	return nil
 }
 
+// Tip returns the hash and order of the last block connected to the index.
+// The hash is resolved from the current internal block ID rather than the
+// stored index tip, so a mismatch between the two reveals an index whose block
+// ID mapping is out of sync with its tip.
+//
+// This is part of the Indexer interface.
+func (idx *TxIndex) Tip(dbTx database.Tx) (*hash.Hash, uint64, error) {
+	_, order, err := dbFetchIndexerTip(dbTx, idx.Key())
+	if err != nil {
+		return nil, 0, err
+	}
+	if idx.curBlockID == 0 {
+		return &hash.ZeroHash, uint64(order), nil
+	}
+	h, err := dbFetchBlockHashByID(dbTx, idx.curBlockID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h, uint64(order), nil
+}
+
 // Key returns the database key to use for the index as a byte slice.
 //
 // This is part of the Indexer interface.
@@ -433,6 +973,13 @@ func (idx *TxIndex) Name() string {
 	return txIndexName
 }
 
+// Version returns the version of the on-disk format of the index.
+//
+// This is part of the Indexer interface.
+func (idx *TxIndex) Version() uint32 {
+	return txIndexVersion
+}
+
 // Create is invoked when the indexer manager determines the index needs
 // to be created for the first time.  It creates the buckets for the hash-based
 // transaction index and the internal block ID indexes.
@@ -449,6 +996,12 @@ func (idx *TxIndex) Create(dbTx database.Tx) error {
 	if _, err := meta.CreateBucket(txidByTxhashBucketName); err != nil {
 		return err
 	}
+	if _, err := meta.CreateBucket(txsByBlockIDBucketName); err != nil {
+		return err
+	}
+	if _, err := meta.CreateBucket(txCountByBlockIDBucketName); err != nil {
+		return err
+	}
 	idx.compatibleOldData(dbTx)
 	if _, err := meta.CreateBucket(itxIndexKey); err != nil {
 		return err
@@ -474,10 +1027,18 @@ func (idx *TxIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock,
 		return fmt.Errorf("no node %s", block.Hash())
 	}
 
+	// A transaction hash that is already indexed for another block has its
+	// entry overwritten unless the index is strict.
+	idx.invalidateRegionCache(dbTx, block)
 	if !node.GetStatus().KnownInvalid() {
-		if err := dbAddTxIndexEntries(dbTx, block, newBlockID); err != nil {
+		if err := dbAddTxIndexEntries(dbTx, block, newBlockID, idx.strict, idx.compact); err != nil {
 			return err
 		}
+		if !idx.compact {
+			if err := dbPutBlockTxs(dbTx, newBlockID, block); err != nil {
+				return err
+			}
+		}
 	} else {
 		if idx.chain.CacheInvalidTx {
 			if err := dbAddInvalidTxIndexEntries(dbTx, block, newBlockID); err != nil {
@@ -492,20 +1053,73 @@ func (idx *TxIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock,
 	if err != nil {
 		return err
 	}
+	err = dbPutBlockTxCount(dbTx, newBlockID, uint32(len(block.Transactions())))
+	if err != nil {
+		return err
+	}
 	idx.curBlockID = newBlockID
+
+	// Record the transactions in the filter, rebuilding it with room to
+	// grow once it holds more than it was sized for.  This happens within
+	// the database transaction of the block, which is the only writer, so
+	// the rebuilt filter can't miss any transaction.
+	if idx.txFilter != nil {
+		txids := make([]*hash.Hash, 0, len(block.Transactions()))
+		for _, tx := range block.Transactions() {
+			txids = append(txids, tx.Hash())
+		}
+		idx.txFilter.Add(txids)
+		if idx.txFilter.NeedsRebuild() {
+			capacity := idx.txFilterCapacity(idx.txFilter.Items() * 2)
+			if err := idx.txFilter.Build(dbTx, capacity, nil); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// invalidateRegionCache evicts the cached regions of the transactions in the
+// passed block, whose entries are about to change in the passed database
+// transaction, when the index has a cache.
+func (idx *TxIndex) invalidateRegionCache(dbTx database.Tx, block *types.SerializedBlock) {
+	if idx.regionCache == nil {
+		return
+	}
+	txids := make([]*hash.Hash, 0, len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		txids = append(txids, tx.Hash())
+	}
+	idx.regionCache.invalidate(dbTx, txids)
+}
+
+// txFilterCapacity returns the number of transactions to size the filter for,
+// which is the estimate derived from the number of indexed blocks or the passed
+// minimum, whichever is larger.
+func (idx *TxIndex) txFilterCapacity(min int) int {
+	capacity := int(idx.curBlockID) * txFilterTxsPerBlock
+	if capacity < min {
+		capacity = min
+	}
+	return capacity
+}
+
 // DisconnectBlock is invoked by the index manager when a block has been
 // disconnected from the main chain.  This indexer removes the
 // hash-to-transaction mapping for every transaction in the block.
 //
 // This is part of the Indexer interface.
 func (idx *TxIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
-	// Remove all of the transactions in the block from the index.
-	if err := dbRemoveTxIndexEntries(dbTx, block); err != nil {
+	// Remove all of the transactions in the block from the index, or
+	// mark them stale when side chain transactions are kept.
+	if idx.sideChain {
+		if err := dbMarkTxIndexEntriesStale(dbTx, block); err != nil {
+			return err
+		}
+	} else if err := dbRemoveTxIndexEntries(dbTx, block); err != nil {
 		return err
 	}
+	idx.invalidateRegionCache(dbTx, block)
 	if idx.chain.CacheInvalidTx {
 		if err := dbRemoveInvalidTxIndexEntries(dbTx, block); err != nil {
 			return err
@@ -514,6 +1128,16 @@ func (idx *TxIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlo
 
 	// Remove the block ID index entry for the block being disconnected and
 	// decrement the current internal block ID to account for it.
+	blockID, err := dbFetchBlockIDByHash(dbTx, block.Hash())
+	if err != nil && err != errNoBlockIDEntry {
+		return err
+	}
+	if err := dbRemoveBlockTxs(dbTx, blockID); err != nil {
+		return err
+	}
+	if err := dbRemoveBlockTxCount(dbTx, blockID); err != nil {
+		return err
+	}
 	if err := dbRemoveBlockIDIndexEntry(dbTx, block.Hash()); err != nil {
 		return err
 	}
@@ -528,55 +1152,668 @@ func (idx *TxIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlo
 //
 // This function is safe for concurrent access.
 func (idx *TxIndex) TxBlockRegion(id hash.Hash) (*database.BlockRegion, error) {
+	if idx.txFilter != nil && !idx.txFilter.MayContain(&id) {
+		return nil, nil
+	}
+	var generation uint64
+	if idx.regionCache != nil {
+		if region := idx.regionCache.lookup(&id); region != nil {
+			return region, nil
+		}
+		generation = idx.regionCache.currentGeneration()
+	}
+
 	var region *database.BlockRegion
 	err := idx.db.View(func(dbTx database.Tx) error {
 		var err error
 		region, err = dbFetchTxIndexEntry(dbTx, &id)
 		return err
 	})
-	return region, err
+	if err == nil && region != nil && idx.regionCache != nil {
+		idx.regionCache.add(&id, region, generation)
+	}
+	return region, toIndexError(err)
 }
 
-func (idx *TxIndex) TxBlockRegionByHash(hash hash.Hash) (*database.BlockRegion, error) {
+// TxBlockRegionIncludingStale returns the block region for the provided
+// transaction hash like TxBlockRegion, but also considers the stale entries
+// kept for blocks disconnected from the main chain by an index created with
+// the SideChain option.  The returned flag is true when the region is from
+// such a stale entry.  When there is no entry for the provided hash, nil will
+// be returned for the both the entry and the error.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) TxBlockRegionIncludingStale(id hash.Hash) (*database.BlockRegion, bool, error) {
 	var region *database.BlockRegion
+	var stale bool
 	err := idx.db.View(func(dbTx database.Tx) error {
 		var err error
-		id, err := dbFetchTxIdByHash(dbTx, hash)
-		if err != nil {
+		region, err = dbFetchTxIndexEntry(dbTx, &id)
+		if err != nil || region != nil {
 			return err
 		}
-		region, err = dbFetchTxIndexEntry(dbTx, id)
+		region, err = dbFetchStaleTxIndexEntry(dbTx, &id)
+		stale = region != nil
 		return err
 	})
-	return region, err
+	if err != nil {
+		return nil, false, toIndexError(err)
+	}
+	return region, stale, nil
 }
 
-func (idx *TxIndex) GetTxIdByHash(txhash hash.Hash) (*hash.Hash, error) {
-	var txid *hash.Hash
+// fetchRegion loads the bytes of the passed block region from the database.
+func (idx *TxIndex) fetchRegion(region *database.BlockRegion) ([]byte, error) {
+	var regionBytes []byte
 	err := idx.db.View(func(dbTx database.Tx) error {
 		var err error
-		id, err := dbFetchTxIdByHash(dbTx, txhash)
-		if err != nil {
-			return err
-		}
-		txid = id
-		return nil
+		regionBytes, err = dbTx.FetchBlockRegion(region)
+		return err
 	})
-	return txid, err
+	if err != nil {
+		return nil, toIndexError(err)
+	}
+	return regionBytes, nil
 }
 
-// NewTxIndex returns a new instance of an indexer that is used to create a
-// mapping of the hashes of all transactions in the blockchain to the respective
-// block, location within the block, and size of the transaction.
+// RawTx returns the serialized bytes of the transaction with the provided hash
+// exactly as stored in its block, without deserializing it.  When there is no
+// entry for the provided hash, nil will be returned for the both the bytes and
+// the error.
 //
-// It implements the Indexer interface which plugs into the IndexManager that in
-// turn is used by the blockchain package.  This allows the index to be
-// seamlessly maintained along with the chain.
-func NewTxIndex(db database.DB) *TxIndex {
-	return &TxIndex{db: db}
+// This function is safe for concurrent access.
+func (idx *TxIndex) RawTx(hash hash.Hash) ([]byte, error) {
+	region, err := idx.TxBlockRegion(hash)
+	if err != nil || region == nil {
+		return nil, err
+	}
+	return idx.fetchRegion(region)
 }
 
-// dropBlockIDIndex drops the internal block id index.
+// FetchTxVerbose loads the transaction with the provided hash using the
+// transaction index and returns its verbose JSON representation, including the
+// hash and order of the containing block and its number of confirmations.  When
+// there is no entry for the provided hash, nil will be returned for the both
+// the result and the error.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) FetchTxVerbose(txHash hash.Hash) (*json.TxRawResult, error) {
+	if idx.chain == nil {
+		return nil, makeIndexError(KindNotReady, fmt.Sprintf("%s is "+
+			"not initialized", txIndexName), nil)
+	}
+	region, err := idx.TxBlockRegion(txHash)
+	if err != nil || region == nil {
+		return nil, err
+	}
+
+	txBytes, err := idx.fetchRegion(region)
+	if err != nil {
+		return nil, err
+	}
+	var msgTx types.Transaction
+	if err := msgTx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return nil, makeIndexError(KindCorruption, fmt.Sprintf("corrupt "+
+			"transaction index entry for %s", txHash), err)
+	}
+	tx := types.NewTx(&msgTx)
+	tx.IsDuplicate = idx.chain.IsDuplicateTx(tx.Hash(), region.Hash)
+
+	var confirmations int64
+	var blockOrder uint64
+	txsvalid := true
+	if ib := idx.chain.BlockDAG().GetBlock(region.Hash); ib != nil {
+		confirmations = int64(idx.chain.BlockDAG().GetConfirmations(ib.GetID()))
+		blockOrder = uint64(ib.GetOrder())
+		txsvalid = !blockchain.BlockStatus(ib.GetStatus()).KnownInvalid()
+	}
+	var coinbaseAmount uint64
+	if msgTx.IsCoinBase() {
+		coinbaseAmount = msgTx.TxOut[0].Amount +
+			uint64(idx.chain.GetFees(region.Hash))
+	}
+
+	result, err := marshal.MarshalJsonTransaction(tx, idx.chain.ChainParams(),
+		region.Hash.String(), confirmations, coinbaseAmount, txsvalid)
+	if err != nil {
+		return nil, err
+	}
+	result.BlockOrder = blockOrder
+	return &result, nil
+}
+
+// TxBlockRegionMinConf returns the block region for the provided transaction
+// hash like TxBlockRegion, but only when the block containing the transaction
+// has at least minConf confirmations given the passed chain height.  When the
+// block is too recent, nil is returned along with an IndexError of kind
+// KindNotReady wrapping ErrInsufficientConfirmations.
+// When there is no entry for the provided hash, nil will be returned for the
+// both the entry and the error.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) TxBlockRegionMinConf(hash hash.Hash, minConf uint32, chainHeight uint64) (*database.BlockRegion, error) {
+	region, err := idx.TxBlockRegion(hash)
+	if err != nil || region == nil {
+		return nil, err
+	}
+	if minConf == 0 {
+		return region, nil
+	}
+
+	if idx.chain == nil {
+		return nil, makeIndexError(KindNotReady, fmt.Sprintf("%s is "+
+			"not initialized", txIndexName), nil)
+	}
+	node := idx.chain.BlockIndex().LookupNode(region.Hash)
+	if node == nil {
+		return nil, makeIndexError(KindNotFound, fmt.Sprintf("no node "+
+			"%s", region.Hash), nil)
+	}
+	height := uint64(node.GetHeight())
+	if height > chainHeight || chainHeight-height+1 < uint64(minConf) {
+		return nil, toIndexError(ErrInsufficientConfirmations)
+	}
+	return region, nil
+}
+
+// IsCoinbaseMature returns whether the outputs of the transaction with the
+// provided hash can be spent by a block extending the chain at the passed
+// height with respect to the passed coinbase maturity.  The height of the
+// containing block is resolved through the index, and the containing block
+// needs at least maturity confirmations for a coinbase to be mature.  Any
+// other transaction is always mature.  An IndexError of kind KindNotFound is
+// returned when there is no entry for the provided hash.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) IsCoinbaseMature(txHash hash.Hash, chainHeight uint64, maturity uint32) (bool, error) {
+	if idx.chain == nil {
+		return false, makeIndexError(KindNotReady, fmt.Sprintf("%s is "+
+			"not initialized", txIndexName), nil)
+	}
+	region, err := idx.TxBlockRegion(txHash)
+	if err != nil {
+		return false, err
+	}
+	if region == nil {
+		return false, makeIndexError(KindNotFound, fmt.Sprintf("no "+
+			"transaction index entry for %s", txHash), nil)
+	}
+
+	txBytes, err := idx.fetchRegion(region)
+	if err != nil {
+		return false, err
+	}
+	var msgTx types.Transaction
+	if err := msgTx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return false, makeIndexError(KindCorruption, fmt.Sprintf("corrupt "+
+			"transaction index entry for %s", txHash), err)
+	}
+	if !msgTx.IsCoinBase() {
+		return true, nil
+	}
+
+	node := idx.chain.BlockIndex().LookupNode(region.Hash)
+	if node == nil {
+		return false, makeIndexError(KindNotFound, fmt.Sprintf("no node "+
+			"%s", region.Hash), nil)
+	}
+	height := uint64(node.GetHeight())
+	return height <= chainHeight && chainHeight-height+1 >= uint64(maturity), nil
+}
+
+// TxSize returns the serialized size of the transaction with the provided hash
+// as stored in its transaction index entry, without resolving its block or
+// loading any block data.  An IndexError of kind KindNotFound is returned when
+// there is no entry for the provided hash.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) TxSize(hash hash.Hash) (uint32, error) {
+	if idx.txFilter != nil && !idx.txFilter.MayContain(&hash) {
+		return 0, makeIndexError(KindNotFound, fmt.Sprintf("no "+
+			"transaction index entry for %s", hash), nil)
+	}
+	var size uint32
+	err := idx.db.View(func(dbTx database.Tx) error {
+		serializedData := dbTx.Metadata().Bucket(txIndexKey).Get(hash[:])
+		if len(serializedData) == 0 || isStaleTxIndexEntry(serializedData) {
+			return makeIndexError(KindNotFound, fmt.Sprintf("no "+
+				"transaction index entry for %s", hash), nil)
+		}
+		entry, err := DeserializeTxIndexEntry(serializedData)
+		if err != nil {
+			return err
+		}
+		size = entry.Len
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// CacheStats returns the number of TxBlockRegion lookups served from and
+// missed by the region cache.  Both are zero when the index has no cache.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) CacheStats() (hits uint64, misses uint64) {
+	if idx.regionCache == nil {
+		return 0, 0
+	}
+	return idx.regionCache.stats()
+}
+
+// TxBlockRegionNoWitness returns the block region covering only the
+// no-witness part of the provided transaction, that is the version and prefix
+// without the trailing timestamp and witness.  Note the serialization type in
+// the upper bits of the version is that of the full serialization.  When there
+// is no entry for the provided hash, nil will be returned for the both the
+// entry and the error.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) TxBlockRegionNoWitness(id hash.Hash) (*database.BlockRegion, error) {
+	var region *database.BlockRegion
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		region, err = dbFetchTxIndexEntry(dbTx, &id)
+		if err != nil || region == nil {
+			return err
+		}
+
+		serializedData := dbTx.Metadata().Bucket(txIndexKey).Get(id[:])
+		if len(serializedData) == txEntrySize {
+			return errNoWitnessLength
+		}
+		entry, err := DeserializeTxIndexEntry(serializedData)
+		if err != nil {
+			return err
+		}
+		witnessLen := entry.WitnessLen
+		if witnessLen > region.Len {
+			return database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("corrupt transaction index "+
+					"entry for %s: witness length %d exceeds "+
+					"tx length %d", id, witnessLen, region.Len),
+			}
+		}
+		region.Len -= witnessLen
+		return nil
+	})
+	if err != nil {
+		return nil, toIndexError(err)
+	}
+	return region, nil
+}
+
+func (idx *TxIndex) TxBlockRegionByHash(hash hash.Hash) (*database.BlockRegion, error) {
+	var region *database.BlockRegion
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		id, err := dbFetchTxIdByHash(dbTx, hash)
+		if err != nil {
+			return err
+		}
+		region, err = dbFetchTxIndexEntry(dbTx, id)
+		return err
+	})
+	return region, toIndexError(err)
+}
+
+func (idx *TxIndex) GetTxIdByHash(txhash hash.Hash) (*hash.Hash, error) {
+	var txid *hash.Hash
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		id, err := dbFetchTxIdByHash(dbTx, txhash)
+		if err != nil {
+			return err
+		}
+		txid = id
+		return nil
+	})
+	return txid, toIndexError(err)
+}
+
+// BlockID returns the internal block ID the transaction index assigned to the
+// block with the provided hash.  The returned flag is false, without an error,
+// when the block is not in the block ID index.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) BlockID(hash hash.Hash) (uint32, bool, error) {
+	var id uint32
+	found := true
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		id, err = dbFetchBlockIDByHash(dbTx, &hash)
+		if err == errNoBlockIDEntry {
+			found = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return 0, false, toIndexError(err)
+	}
+	return id, found, nil
+}
+
+// BlockHashesByIDs resolves the provided internal block IDs to their block
+// hashes within a single database transaction.  The returned slice lines up
+// with ids and holds nil for any ID that is not in the block ID index.
+// Repeated IDs are only looked up once.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) BlockHashesByIDs(ids []uint32) ([]*hash.Hash, error) {
+	hashes := make([]*hash.Hash, len(ids))
+	err := idx.db.View(func(dbTx database.Tx) error {
+		resolved := make(map[uint32]*hash.Hash)
+		for i, id := range ids {
+			if h, ok := resolved[id]; ok {
+				hashes[i] = h
+				continue
+			}
+			h, err := dbFetchBlockHashByID(dbTx, id)
+			if err != nil && err != errNoBlockIDEntry {
+				return err
+			}
+			resolved[id] = h
+			hashes[i] = h
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toIndexError(err)
+	}
+	return hashes, nil
+}
+
+// GroupTxsByBlock resolves the block containing each of the provided
+// transactions and returns the transaction hashes keyed by the hash of their
+// block, in the order they were provided.  Transactions that are not in the
+// index are left out, and repeated hashes are only listed once.  All lookups
+// happen within a single database transaction and each block ID is resolved
+// only once.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) GroupTxsByBlock(hashes []hash.Hash) (map[hash.Hash][]hash.Hash, error) {
+	groups := make(map[hash.Hash][]hash.Hash)
+	err := idx.db.View(func(dbTx database.Tx) error {
+		txIndex := dbTx.Metadata().Bucket(txIndexKey)
+		blockHashes := make(map[uint32]*hash.Hash)
+		seen := make(map[hash.Hash]struct{}, len(hashes))
+		for i := range hashes {
+			txHash := &hashes[i]
+			if _, ok := seen[*txHash]; ok {
+				continue
+			}
+			seen[*txHash] = struct{}{}
+			if idx.txFilter != nil && !idx.txFilter.MayContain(txHash) {
+				continue
+			}
+
+			serializedData := txIndex.Get(txHash[:])
+			if len(serializedData) == 0 ||
+				isStaleTxIndexEntry(serializedData) {
+				continue
+			}
+			entry, err := DeserializeTxIndexEntry(serializedData)
+			if err != nil {
+				return err
+			}
+			blockHash, ok := blockHashes[entry.BlockID]
+			if !ok {
+				blockHash, err = dbFetchBlockHashByID(dbTx, entry.BlockID)
+				if err != nil {
+					return database.Error{
+						ErrorCode: database.ErrCorruption,
+						Description: fmt.Sprintf("corrupt transaction "+
+							"index entry for %s: %v", txHash, err),
+					}
+				}
+				blockHashes[entry.BlockID] = blockHash
+			}
+			groups[*blockHash] = append(groups[*blockHash], *txHash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toIndexError(err)
+	}
+	return groups, nil
+}
+
+// TxCountInBlock returns the number of transactions in the block with the
+// provided hash from the ID to transaction count bucket, so the block itself is
+// not loaded.  A block that is not in the index results in an IndexError of
+// kind KindNotFound.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) TxCountInBlock(blockHash hash.Hash) (uint32, error) {
+	var count uint32
+	err := idx.db.View(func(dbTx database.Tx) error {
+		id, err := dbFetchBlockIDByHash(dbTx, &blockHash)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		count, ok, err = dbFetchBlockTxCount(dbTx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return makeIndexError(KindNotFound, fmt.Sprintf("no "+
+				"transaction count for block %s", blockHash), nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, toIndexError(err)
+	}
+	return count, nil
+}
+
+// ForEachBlockID invokes fn with every internal block ID and the hash of its
+// block in the block ID index, in ascending key order of the bucket, so
+// callers can audit the whole index without holding it in memory.  The
+// iteration happens within a single database transaction and stops with the
+// first error returned by fn.  Malformed entries result in an IndexError of
+// kind KindCorruption.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) ForEachBlockID(fn func(uint32, hash.Hash) error) error {
+	err := idx.db.View(func(dbTx database.Tx) error {
+		idIndex := dbTx.Metadata().Bucket(hashByIDIndexBucketName)
+		if idIndex == nil {
+			return makeIndexError(KindNotReady, fmt.Sprintf("%s bucket "+
+				"%s does not exist", txIndexName,
+				hashByIDIndexBucketName), nil)
+		}
+		return idIndex.ForEach(func(k, v []byte) error {
+			if len(k) != 4 || len(v) != hash.HashSize {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt block ID "+
+						"entry %x: %d byte hash", k, len(v)),
+				}
+			}
+			var h hash.Hash
+			copy(h[:], v)
+			return fn(byteOrder.Uint32(k), h)
+		})
+	})
+	return toIndexError(err)
+}
+
+// DumpBlockIDIndex returns the whole block ID index as a mapping from internal
+// block IDs to block hashes.  See ForEachBlockID for a variant that does not
+// build the mapping in memory.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) DumpBlockIDIndex() (map[uint32]hash.Hash, error) {
+	ids := make(map[uint32]hash.Hash)
+	err := idx.ForEachBlockID(func(id uint32, h hash.Hash) error {
+		ids[id] = h
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// BlockIDByOrder returns the internal block ID the transaction index assigned
+// to the block at the provided order of the DAG.  Block IDs follow the order
+// in which blocks were connected, so this is how block orders relate to the
+// entries of the index.  The returned flag is false, without an error, when
+// there is no block at the order or it is not in the block ID index.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) BlockIDByOrder(order uint32) (uint32, bool, error) {
+	if idx.chain == nil {
+		return 0, false, makeIndexError(KindNotReady, fmt.Sprintf("%s "+
+			"is not initialized", txIndexName), nil)
+	}
+	h := idx.chain.BlockDAG().GetBlockByOrder(uint(order))
+	if h == nil {
+		return 0, false, nil
+	}
+	return idx.BlockID(*h)
+}
+
+// TxsInOrderRange returns the hashes of the transactions in the blocks from
+// startOrder through endOrder, inclusive.  The transactions are grouped by
+// block in order of the blocks and in block order within each block.  They are
+// read from the ID to transactions bucket without loading the blocks, except
+// for blocks without an entry there, such as every block of an index with the
+// Compact option, which are loaded from the database instead.  Blocks known to
+// be invalid contribute no transactions.
+//
+// This function is safe for concurrent access.
+func (idx *TxIndex) TxsInOrderRange(startOrder, endOrder uint32) ([]hash.Hash, error) {
+	if idx.chain == nil {
+		return nil, makeIndexError(KindNotReady, fmt.Sprintf("%s is "+
+			"not initialized", txIndexName), nil)
+	}
+	if endOrder < startOrder {
+		return nil, fmt.Errorf("end order %d is before start order %d",
+			endOrder, startOrder)
+	}
+
+	// Resolve the block hashes first so the DAG lock isn't held while the
+	// database transaction is open.  There can't be more blocks than the
+	// DAG holds, however large the range.
+	count := uint64(endOrder-startOrder) + 1
+	if total := uint64(idx.chain.BlockDAG().GetBlockTotal()); count > total {
+		count = total
+	}
+	blockHashes := make([]*hash.Hash, 0, count)
+	for order := uint64(startOrder); order <= uint64(endOrder); order++ {
+		h := idx.chain.BlockDAG().GetBlockByOrder(uint(order))
+		if h == nil {
+			break
+		}
+		blockHashes = append(blockHashes, h)
+	}
+
+	var txs []hash.Hash
+	err := idx.db.View(func(dbTx database.Tx) error {
+		for _, h := range blockHashes {
+			id, err := dbFetchBlockIDByHash(dbTx, h)
+			if err == errNoBlockIDEntry {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			blockTxs, err := dbFetchBlockTxs(dbTx, id)
+			if err != nil {
+				return err
+			}
+			if blockTxs == nil {
+				node := idx.chain.BlockIndex().LookupNode(h)
+				if node == nil || node.GetStatus().KnownInvalid() {
+					continue
+				}
+				block, err := dbFetchBlock(dbTx, h)
+				if err != nil {
+					return err
+				}
+				for _, tx := range block.Transactions() {
+					blockTxs = append(blockTxs, *tx.Hash())
+				}
+			}
+			txs = append(txs, blockTxs...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toIndexError(err)
+	}
+	return txs, nil
+}
+
+// NewTxIndex returns a new instance of an indexer that is used to create a
+// mapping of the hashes of all transactions in the blockchain to the respective
+// block, location within the block, and size of the transaction.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewTxIndex(db database.DB) *TxIndex {
+	return &TxIndex{db: db}
+}
+
+// TxIndexOptions holds the optional behaviors of a transaction index created
+// with NewTxIndexWithOptions.  They can be combined freely and the zero value
+// gives the same index as NewTxIndex.
+type TxIndexOptions struct {
+	// CacheSize is the number of recently looked up transactions whose
+	// block regions are kept in memory.  Zero disables the cache.
+	CacheSize int
+
+	// Strict makes connecting a block containing a transaction whose hash
+	// is already indexed for a different block fail with an IndexError of
+	// kind KindDuplicate, rather than silently replacing the old entry.
+	Strict bool
+
+	// SideChain keeps the entries of transactions in blocks disconnected
+	// from the main chain, marked as stale, rather than removing them.
+	// They can be looked up with TxBlockRegionIncludingStale.
+	SideChain bool
+
+	// Compact stores the block ID of new entries as a varint to reduce the
+	// size of the index.  Existing fixed size entries remain readable.
+	Compact bool
+
+	// Filter keeps an in-memory bloom filter of the indexed transactions,
+	// so TxBlockRegion lookups the filter rules out return without reading
+	// the database.
+	Filter bool
+
+	// FilterFPRate is the false positive rate of the filter.  A rate
+	// outside (0, 1) selects DefaultTxFilterFPRate.
+	FilterFPRate float64
+}
+
+// NewTxIndexWithOptions returns a new transaction index like NewTxIndex with
+// the optional behaviors selected by the passed options.
+func NewTxIndexWithOptions(db database.DB, opts TxIndexOptions) *TxIndex {
+	idx := &TxIndex{
+		db:        db,
+		strict:    opts.Strict,
+		sideChain: opts.SideChain,
+		compact:   opts.Compact,
+	}
+	if opts.CacheSize > 0 {
+		idx.regionCache = newTxRegionCache(opts.CacheSize)
+	}
+	if opts.Filter {
+		idx.txFilter = newTxFilter(opts.FilterFPRate)
+	}
+	return idx
+}
+
+// dropBlockIDIndex drops the internal block id index.
 func dropBlockIDIndex(db database.DB) error {
 	return db.Update(func(dbTx database.Tx) error {
 		meta := dbTx.Metadata()
@@ -588,10 +1825,274 @@ func dropBlockIDIndex(db database.DB) error {
 		if err != nil {
 			return err
 		}
+		for _, name := range [][]byte{txsByBlockIDBucketName,
+			txCountByBlockIDBucketName} {
+			if meta.Bucket(name) != nil {
+				if err := meta.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
 		return meta.DeleteBucket(hashByIDIndexBucketName)
 	})
 }
 
+// blockIDRebuildBatchSize is the number of block ID index entries written per
+// database transaction by RebuildBlockIDIndex.
+const blockIDRebuildBatchSize = 10000
+
+// Migrate migrates a transaction index written with an older version of its
+// on-disk format.  Version 2 added the block ID to transaction count bucket,
+// which is backfilled for every block in the block ID index.
+//
+// This is part of the IndexMigrator interface.
+func (idx *TxIndex) Migrate(oldVersion uint32) error {
+	if oldVersion < 3 {
+		if err := idx.backfillBlockTxs(); err != nil {
+			return err
+		}
+	}
+	if oldVersion < 2 {
+		return idx.backfillTxCounts()
+	}
+	return nil
+}
+
+// backfillBlockTxs creates the ID to transactions bucket as needed and adds the
+// transaction hashes of every block in the block ID index that has none.  Only
+// blocks whose coinbase is indexed for them get an entry, which leaves out the
+// blocks known to be invalid, and nothing is added to an index with the
+// Compact option, since it doesn't keep the hashes per block.  The entries are
+// written in batches of blockIDRebuildBatchSize blocks, so an error part way
+// leaves the entries written so far and the backfill can simply be run again.
+func (idx *TxIndex) backfillBlockTxs() error {
+	err := idx.db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(
+			txsByBlockIDBucketName)
+		return err
+	})
+	if err != nil || idx.compact {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Adding the block transactions to the %s",
+		txIndexName))
+	done := false
+	for start := uint32(1); !done; start += blockIDRebuildBatchSize {
+		err := idx.db.Update(func(dbTx database.Tx) error {
+			for id := start; id < start+blockIDRebuildBatchSize; id++ {
+				blockHash, err := dbFetchBlockHashByID(dbTx, id)
+				if err == errNoBlockIDEntry {
+					done = true
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				serializedTxs, err := dbFetchSerializedBlockTxs(dbTx, id)
+				if err != nil {
+					return err
+				}
+				if serializedTxs != nil {
+					continue
+				}
+				block, err := dbFetchBlock(dbTx, blockHash)
+				if err != nil {
+					return err
+				}
+				txns := block.Transactions()
+				if len(txns) == 0 {
+					continue
+				}
+				region, err := dbFetchTxIndexEntry(dbTx, txns[0].Hash())
+				if err != nil {
+					return err
+				}
+				if region == nil || !region.Hash.IsEqual(blockHash) {
+					continue
+				}
+				if err := dbPutBlockTxs(dbTx, id, block); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbFetchBlock uses an existing database transaction to load the block with
+// the provided hash.
+func dbFetchBlock(dbTx database.Tx, blockHash *hash.Hash) (*types.SerializedBlock, error) {
+	blockBytes, err := dbTx.FetchBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewBlockFromBytes(blockBytes)
+}
+
+// backfillTxCounts creates the block ID to transaction count bucket as needed
+// and adds the count of every block in the block ID index that has none.  The
+// blocks are counted by dbCountBlockTxs and the entries are written in batches
+// of blockIDRebuildBatchSize blocks, so an error part way leaves the counts
+// written so far and the backfill can simply be run again.
+func (idx *TxIndex) backfillTxCounts() error {
+	err := idx.db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(
+			txCountByBlockIDBucketName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Adding the block transaction counts to the %s",
+		txIndexName))
+	done := false
+	for start := uint32(1); !done; start += blockIDRebuildBatchSize {
+		err := idx.db.Update(func(dbTx database.Tx) error {
+			for id := start; id < start+blockIDRebuildBatchSize; id++ {
+				blockHash, err := dbFetchBlockHashByID(dbTx, id)
+				if err == errNoBlockIDEntry {
+					done = true
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				_, ok, err := dbFetchBlockTxCount(dbTx, id)
+				if err != nil {
+					return err
+				}
+				if ok {
+					continue
+				}
+				count, err := dbCountBlockTxs(dbTx, id, blockHash)
+				if err != nil {
+					return err
+				}
+				if err := dbPutBlockTxCount(dbTx, id, count); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildBlockIDIndex regenerates the block hash to ID and ID to block hash
+// mappings of the transaction index from the main chain, leaving the
+// transaction entries untouched.  Since the index connects every block in
+// order, the block with order n always has block ID n+1, so the mappings are
+// rebuilt from the block hashes up to the index tip without loading any block.
+// The entries are written in batches and the next order to write is kept in the
+// database along with each batch until the rebuild is done.  When the rebuild
+// is interrupted, Init refuses to use the index and calling this function again
+// resumes the rebuild where it stopped.
+//
+// This function is NOT safe for concurrent access.  It must only be called
+// while the transaction index is not in use, such as before the index manager
+// is initialized.
+func RebuildBlockIDIndex(db database.DB, chain *blockchain.BlockChain) error {
+	var tipOrder, next uint32
+	err := db.Update(func(dbTx database.Tx) error {
+		tipHash, order, err := dbFetchIndexerTip(dbTx, txIndexKey)
+		if err != nil {
+			return err
+		}
+		if order != math.MaxUint32 {
+			h := chain.BlockDAG().GetBlockByOrder(uint(order))
+			if h == nil || !h.IsEqual(tipHash) {
+				return fmt.Errorf("%s tip %s at order %d is not on "+
+					"the main chain", txIndexName, tipHash, order)
+			}
+		}
+		tipOrder = order
+
+		// Resume an unfinished rebuild.
+		meta := dbTx.Metadata()
+		if serialized := meta.Get(blockIDRebuildKeyName); serialized != nil {
+			if len(serialized) != 4 {
+				return database.Error{
+					ErrorCode:   database.ErrCorruption,
+					Description: "malformed block ID index rebuild progress",
+				}
+			}
+			next = byteOrder.Uint32(serialized)
+			return nil
+		}
+
+		for _, name := range [][]byte{idByHashIndexBucketName,
+			hashByIDIndexBucketName} {
+			if meta.Bucket(name) != nil {
+				if err := meta.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			if _, err := meta.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return dbPutBlockIDRebuildProgress(dbTx, 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nothing to rebuild when the index is empty.
+	if tipOrder == math.MaxUint32 {
+		return db.Update(func(dbTx database.Tx) error {
+			return dbTx.Metadata().Delete(blockIDRebuildKeyName)
+		})
+	}
+
+	log.Info(fmt.Sprintf("Rebuilding the block ID index of the %s from "+
+		"order %d up to order %d", txIndexName, next, tipOrder))
+	for start := uint64(next); start <= uint64(tipOrder); start += blockIDRebuildBatchSize {
+		end := start + blockIDRebuildBatchSize - 1
+		if end > uint64(tipOrder) {
+			end = uint64(tipOrder)
+		}
+		err := db.Update(func(dbTx database.Tx) error {
+			for order := start; order <= end; order++ {
+				h := chain.BlockDAG().GetBlockByOrder(uint(order))
+				if h == nil {
+					return fmt.Errorf("no block at order %d", order)
+				}
+				err := dbPutBlockIDIndexEntry(dbTx, h, uint32(order+1))
+				if err != nil {
+					return err
+				}
+			}
+			if end == uint64(tipOrder) {
+				return dbTx.Metadata().Delete(blockIDRebuildKeyName)
+			}
+			return dbPutBlockIDRebuildProgress(dbTx, uint32(end+1))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info(fmt.Sprintf("Rebuilt the block ID index of the %s", txIndexName))
+	return nil
+}
+
+// dbPutBlockIDRebuildProgress stores the next order RebuildBlockIDIndex has to
+// write.
+func dbPutBlockIDRebuildProgress(dbTx database.Tx, next uint32) error {
+	var serialized [4]byte
+	byteOrder.PutUint32(serialized[:], next)
+	return dbTx.Metadata().Put(blockIDRebuildKeyName, serialized[:])
+}
+
 func dropInvalidTx(db database.DB) error {
 	return db.Update(func(dbTx database.Tx) error {
 		meta := dbTx.Metadata()
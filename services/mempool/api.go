@@ -1,6 +1,7 @@
 package mempool
 
 import (
+	"github.com/Qitmeer/qitmeer/core/json"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/rpc"
 	"sort"
@@ -35,3 +36,16 @@ func (api *PublicMempoolAPI) GetMempool(txType *string, verbose bool) (interface
 	sort.Strings(hashStrings)
 	return hashStrings, nil
 }
+
+// GetMempoolInfo returns the size and fee policy of the memory pool.  The
+// pool is not bounded by size, so maxmempool is reported as zero.
+func (api *PublicMempoolAPI) GetMempoolInfo() (interface{}, error) {
+	size, bytes, usage := api.txPool.Stats()
+	return &json.GetMempoolInfoResult{
+		Size:          size,
+		Bytes:         bytes,
+		Usage:         usage,
+		MaxMempool:    0,
+		MempoolMinFee: api.txPool.cfg.Policy.MinRelayTxFee.ToCoin(),
+	}, nil
+}
@@ -24,7 +24,8 @@ import (
 // peers.
 type TxPool struct {
 	// The following variables must only be used atomically.
-	lastUpdated int64 // last time pool was updated.
+	lastUpdated int64  // last time pool was updated.
+	generation  uint64 // number of times pool was updated.
 
 	mtx           sync.RWMutex
 	cfg           Config
@@ -76,6 +77,26 @@ func (mp *TxPool) TxDescs() []*TxDesc {
 	return descs
 }
 
+// Stats returns the number of transactions in the pool, their total
+// serialized size and the serialized size of every transaction held by the
+// pool including orphans.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Stats() (int, uint64, uint64) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	var bytes uint64
+	for _, desc := range mp.pool {
+		bytes += uint64(desc.Tx.Tx.SerializeSize())
+	}
+	usage := bytes
+	for _, tx := range mp.orphans {
+		usage += uint64(tx.Tx.SerializeSize())
+	}
+	return len(mp.pool), bytes, usage
+}
+
 // removeTransaction is the internal function which implements the public
 // RemoveTransaction.  See the comment for RemoveTransaction for more details.
 //
@@ -108,6 +129,7 @@ func (mp *TxPool) removeTransaction(theTx *types.Tx, removeRedeemers bool) {
 		}
 		delete(mp.pool, *txHash)
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+		atomic.AddUint64(&mp.generation, 1)
 	}
 }
 
@@ -169,6 +191,7 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 		mp.outpoints[txIn.PreviousOut] = tx
 	}
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+	atomic.AddUint64(&mp.generation, 1)
 
 	// Add unconfirmed address index entries associated with the transaction
 	// if enabled.
@@ -912,6 +935,15 @@ func (mp *TxPool) LastUpdated() time.Time {
 	return time.Unix(atomic.LoadInt64(&mp.lastUpdated), 0)
 }
 
+// Generation returns a counter that is incremented every time a transaction is
+// added to or removed from the main pool.  Unlike LastUpdated, it changes for
+// every update, even several within the same second.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Generation() uint64 {
+	return atomic.LoadUint64(&mp.generation)
+}
+
 // MiningDescs returns a slice of mining descriptors for all the transactions
 // in the pool.
 //
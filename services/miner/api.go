@@ -5,11 +5,16 @@ package miner
 import (
 	"encoding/hex"
 	"fmt"
+	"github.com/Qitmeer/qitmeer/core/address"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/merkle"
 	"github.com/Qitmeer/qitmeer/core/types/pow"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"math/big"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,11 +32,58 @@ import (
 // RPC.
 const gbtNonceRange = "00000000ffffffff"
 
-// gbtRegenerateSeconds is the number of seconds that must pass before
-// a new template is generated when the previous block hash has not
-// changed and there have been changes to the available transactions
-// in the memory pool.
-const gbtRegenerateSeconds = 60
+// maxGenerateBlocks is the maximum number of blocks a single generate or
+// generatetoaddress call may mine.
+const maxGenerateBlocks = 3000
+
+// gbtCapabilities describes additional capabilities returned with a block
+// template generated by the getblocktemplate RPC.  They are also the only
+// capabilities a caller may require.  Long polling and proposal mode are not
+// supported, so longpoll and proposal are not among them.
+var gbtCapabilities = []string{"coinbasetxn", "coinbasevalue"}
+
+// The following are the reject reasons reported by submitblock.  They follow
+// the BIP 0022 convention of short, hyphenated categories so callers can
+// decide whether a share is worth resubmitting.
+const (
+	rejectDuplicate     = "duplicate"
+	rejectBadMerkleRoot = "bad-merkle-root"
+	rejectBadPow        = "bad-pow"
+	rejectStaleParent   = "stale-parent"
+	rejectStaleTemplate = "stale-template"
+	rejectBadTime       = "time-invalid"
+	rejectBadCoinbase   = "bad-cb"
+	rejectOrphan        = "orphan"
+	rejectRejected      = "rejected"
+	rejectUnexpected    = "unexpected"
+)
+
+// rejectReason maps a consensus rule error code onto the submitblock reject
+// category it belongs to.
+func rejectReason(code blockchain.ErrorCode) string {
+	switch code {
+	case blockchain.ErrDuplicateBlock:
+		return rejectDuplicate
+	case blockchain.ErrBadMerkleRoot, blockchain.ErrBadParentsMerkleRoot:
+		return rejectBadMerkleRoot
+	case blockchain.ErrHighHash, blockchain.ErrDifficultyTooLow,
+		blockchain.ErrUnexpectedDifficulty, blockchain.ErrBadCuckooNonces,
+		blockchain.ErrInValidPowType, blockchain.ErrInvalidPow:
+		return rejectBadPow
+	case blockchain.ErrMissingParent, blockchain.ErrParentsBlockUnknown,
+		blockchain.ErrInvalidTemplateParent, blockchain.ErrPrevBlockNotBest:
+		return rejectStaleParent
+	case blockchain.ErrInvalidTime, blockchain.ErrTimeTooOld,
+		blockchain.ErrTimeTooNew:
+		return rejectBadTime
+	case blockchain.ErrFirstTxNotCoinbase, blockchain.ErrMultipleCoinbases,
+		blockchain.ErrCoinbaseHeight, blockchain.ErrMissingCoinbaseHeight,
+		blockchain.ErrBadCoinbaseScriptLen, blockchain.ErrBadCoinbaseValue,
+		blockchain.ErrBadCoinbaseOutpoint, blockchain.ErrBadCoinbaseAmountIn:
+		return rejectBadCoinbase
+	}
+	return rejectRejected
+}
 
 func (c *CPUMiner) APIs() []rpc.API {
 	return []rpc.API{
@@ -52,11 +104,18 @@ type PublicMinerAPI struct {
 	miner          *CPUMiner
 	gbtWorkState   *gbtWorkState
 	gbtCoinbaseAux *json.GetBlockTemplateResultAux
+	submitJobs     *submitJobs
+	getWorkState   *getWorkState
 }
 
 func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
 	pmAPI := &PublicMinerAPI{miner: c}
 	pmAPI.gbtWorkState = &gbtWorkState{timeSource: c.timeSource}
+	pmAPI.submitJobs = newSubmitJobs()
+	pmAPI.getWorkState = &getWorkState{
+		blocks:    make(map[hash.Hash]*types.Block),
+		templates: make(map[pow.PowType]*gbtWorkState),
+	}
 
 	pmAPI.gbtCoinbaseAux = &json.GetBlockTemplateResultAux{
 		Flags: hex.EncodeToString(builderScript(txscript.NewScriptBuilder().
@@ -66,10 +125,17 @@ func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
 }
 
 //func (api *PublicMinerAPI) GetBlockTemplate(request *mining.TemplateRequest) (interface{}, error){
-func (api *PublicMinerAPI) GetBlockTemplate(capabilities []string) (interface{}, error) {
+func (api *PublicMinerAPI) GetBlockTemplate(capabilities []string, powType *pow.PowType) (interface{}, error) {
 	// Set the default mode and override it if supplied.
 	mode := "template"
-	request := json.TemplateRequest{Mode: mode, Capabilities: capabilities}
+	request := json.TemplateRequest{Mode: mode, Capabilities: capabilities,
+		PowType: uint8(pow.QITMEERKECCAK256)}
+	if powType != nil {
+		if _, ok := pow.PowMapString[*powType]; !ok {
+			return nil, rpc.RpcInvalidError("Unknown pow type %d", *powType)
+		}
+		request.PowType = uint8(*powType)
+	}
 	switch mode {
 	case "template":
 		return handleGetBlockTemplateRequest(api, &request)
@@ -89,27 +155,315 @@ func (api *PublicMinerAPI) SubmitBlock(hexBlock string) (interface{}, error) {
 	m.submitBlockLock.Lock()
 	defer m.submitBlockLock.Unlock()
 
+	block, err := api.decodeSubmittedBlock(hexBlock)
+	if err != nil {
+		return nil, err
+	}
+	return api.processSubmittedBlock(block)
+}
+
+// SubmitBlockBytes submits a serialized block like SubmitBlock, but takes the
+// raw block bytes rather than their hex encoding.  It is meant for services
+// handing the miner blocks within the process, which saves encoding the block
+// to hex only to decode it again.
+func (api *PublicMinerAPI) SubmitBlockBytes(serializedBlock []byte) (interface{}, error) {
+	m := api.miner
+	m.submitBlockLock.Lock()
+	defer m.submitBlockLock.Unlock()
+
+	block, err := api.deserializeSubmittedBlock(serializedBlock)
+	if err != nil {
+		return nil, err
+	}
+	return api.processSubmittedBlock(block)
+}
+
+// SubmitBlockAsync deserializes the passed block synchronously and then
+// processes it in the background.  The returned job id can be passed to
+// GetSubmitResult to retrieve the outcome once processing is done.
+func (api *PublicMinerAPI) SubmitBlockAsync(hexBlock string) (string, error) {
+	block, err := api.decodeSubmittedBlock(hexBlock)
+	if err != nil {
+		return "", err
+	}
+
+	job := api.submitJobs.newJob()
+	go func() {
+		m := api.miner
+		m.submitBlockLock.Lock()
+		result, err := api.processSubmittedBlock(block)
+		m.submitBlockLock.Unlock()
+		api.submitJobs.finish(job, result, err)
+	}()
+	return job.id, nil
+}
+
+// GetSubmitResult returns the state of a block submitted via
+// SubmitBlockAsync.  Finished jobs are kept around for submitJobExpiry.
+func (api *PublicMinerAPI) GetSubmitResult(jobID string) (*json.SubmitBlockJobResult, error) {
+	result := api.submitJobs.lookup(jobID)
+	if result == nil {
+		return nil, rpc.RpcInvalidError("Unknown submit job id %s", jobID)
+	}
+	return result, nil
+}
+
+// GetNextDifficulty returns the difficulty the next block would be required to
+// meet for each of the blake2bd, cuckaroo and cuckatoo pow types given the
+// current chain state and adjusted time.
+func (api *PublicMinerAPI) GetNextDifficulty() (*json.PowDiff, error) {
+	return api.nextDifficulty(api.miner.timeSource.AdjustedTime())
+}
+
+// GetNextBlockContext returns the parents, height and order a block template
+// built now would have, along with the difficulty required of it for each of
+// the blake2bd, cuckaroo and cuckatoo pow types.  It is much cheaper than
+// GetBlockTemplate since no transactions are selected and no block is built.
+func (api *PublicMinerAPI) GetNextBlockContext() (*json.NextBlockContext, error) {
+	chain := api.miner.blockManager.GetChain()
+	best := chain.BestSnapshot()
+	tips := chain.GetMiningTips()
+	parents := make([]string, 0, len(tips))
+	for _, tip := range tips {
+		parents = append(parents, tip.String())
+	}
+
+	diffs, err := api.nextDifficulty(api.miner.timeSource.AdjustedTime())
+	if err != nil {
+		return nil, err
+	}
+	return &json.NextBlockContext{
+		Parents:    parents,
+		NextHeight: uint64(chain.BlockDAG().GetMainChainTip().GetHeight() + 1),
+		NextOrder:  uint32(best.GraphState.GetTotal()),
+		PowDiffs:   *diffs,
+	}, nil
+}
+
+// SimulateRetarget projects the difficulty required of a block mined once the
+// passed number of blocks worth of target block time has passed, for each of
+// the blake2bd, cuckaroo and cuckatoo pow types.  The retarget itself only
+// depends on blocks already in the chain, so the projection differs from
+// GetNextDifficulty only on networks which reduce the difficulty after a long
+// time without blocks.
+func (api *PublicMinerAPI) SimulateRetarget(blocks int) (*json.PowDiff, error) {
+	if blocks < 0 {
+		return nil, rpc.RpcInvalidError("Block count %d must not be "+
+			"negative", blocks)
+	}
+	elapsed := time.Duration(blocks) * api.miner.params.TargetTimePerBlock
+	return api.nextDifficulty(api.miner.timeSource.AdjustedTime().Add(elapsed))
+}
+
+// nextDifficulty returns the difficulty of a block after the current chain tip
+// with the passed timestamp for each supported pow type.
+func (api *PublicMinerAPI) nextDifficulty(timestamp time.Time) (*json.PowDiff, error) {
+	chain := api.miner.blockManager.GetChain()
+	var diffs [3]float64
+	for i, powType := range []pow.PowType{pow.BLAKE2BD, pow.CUCKAROO, pow.CUCKATOO} {
+		bits, err := chain.CalcNextRequiredDifficulty(timestamp, powType)
+		if err != nil {
+			return nil, rpc.RpcInternalError(err.Error(),
+				"Could not calculate next difficulty")
+		}
+		diffs[i] = difficultyRatio(pow.CompactToBig(bits), api.miner.params, powType)
+	}
+	return &json.PowDiff{
+		Blake2bdDiff: diffs[0],
+		CuckarooDiff: diffs[1],
+		CuckatooDiff: diffs[2],
+	}, nil
+}
+
+// difficultyRatio returns the proof-of-work difficulty of the passed target as
+// a multiple of the minimum difficulty of its pow type.
+func difficultyRatio(target *big.Int, params *params.Params, powType pow.PowType) float64 {
+	instance := pow.GetInstance(powType, 0, []byte{})
+	instance.SetParams(params.PowConfig)
+	base := instance.GetSafeDiff(0)
+	var difficulty *big.Rat
+	if powType == pow.BLAKE2BD {
+		difficulty = new(big.Rat).SetFrac(base, target)
+	} else {
+		difficulty = new(big.Rat).SetFrac(target, base)
+	}
+	diff, _ := difficulty.Float64()
+	return diff
+}
+
+// ValidateTemplate performs a dry run of the internal consistency checks on a
+// block built from a template without processing it.  The merkle root in the
+// header, the witness commitment of the coinbase, the height encoded in the
+// coinbase and the order of the transactions are each checked and reported
+// separately.
+func (api *PublicMinerAPI) ValidateTemplate(hexBlock string) (*json.TemplateValidationResult, error) {
+	block, err := api.decodeSubmittedBlock(hexBlock)
+	if err != nil {
+		return nil, err
+	}
+	result := &json.TemplateValidationResult{}
+	if len(block.Transactions()) == 0 {
+		noTxns := json.TemplateCheckResult{Error: "block does not contain " +
+			"any transactions"}
+		result.MerkleRoot = noTxns
+		result.WitnessCommitment = noTxns
+		result.CoinbaseHeight = noTxns
+		result.TxOrder = noTxns
+		return result, nil
+	}
+
+	header := &block.Block().Header
+	merkleRoot := merkle.CalcTxMerkleRoot(block.Transactions(), false)
+	if header.TxRoot.IsEqual(&merkleRoot) {
+		result.MerkleRoot.Passed = true
+	} else {
+		result.MerkleRoot.Error = fmt.Sprintf("header indicates %v, but "+
+			"calculated value is %v", header.TxRoot, merkleRoot)
+	}
+
+	if err := merkle.ValidateWitnessCommitment(block); err != nil {
+		result.WitnessCommitment.Error = err.Error()
+	} else {
+		result.WitnessCommitment.Passed = true
+	}
+
+	if err := api.checkTemplateCoinbaseHeight(block); err != nil {
+		result.CoinbaseHeight.Error = err.Error()
+	} else {
+		result.CoinbaseHeight.Passed = true
+	}
+
+	if err := merkle.CheckTxOrder(block.Transactions()); err != nil {
+		result.TxOrder.Error = err.Error()
+	} else {
+		result.TxOrder.Passed = true
+	}
+
+	result.Valid = result.MerkleRoot.Passed &&
+		result.WitnessCommitment.Passed && result.CoinbaseHeight.Passed &&
+		result.TxOrder.Passed
+	return result, nil
+}
+
+// checkTemplateCoinbaseHeight ensures the height encoded in the coinbase of the
+// passed block is one more than the height of the main parent of its parents.
+func (api *PublicMinerAPI) checkTemplateCoinbaseHeight(block *types.SerializedBlock) error {
+	height, err := blockchain.ExtractCoinbaseHeight(block.Block().Transactions[0])
+	if err != nil {
+		return err
+	}
+
+	bd := api.miner.blockManager.GetChain().BlockDAG()
+	parents := block.Block().Parents
+	if len(parents) == 0 {
+		return fmt.Errorf("block has no parents")
+	}
+	for _, parent := range parents {
+		if !bd.HasBlock(parent) {
+			return fmt.Errorf("unknown parent %v", parent)
+		}
+	}
+	mainParent := bd.GetMainParent(bd.GetIdSet(parents))
+	if mainParent == nil {
+		return fmt.Errorf("unable to find the main parent")
+	}
+	expected := uint64(mainParent.GetHeight() + 1)
+	if height != expected {
+		return fmt.Errorf("coinbase height is %d when %d was expected",
+			height, expected)
+	}
+	return nil
+}
+
+// maxSubmittedBlockSize returns the largest serialized block size the active
+// network allows, which bounds the size of submitted blocks.  The maximum block
+// message size is used when the network does not define any block sizes.
+func (api *PublicMinerAPI) maxSubmittedBlockSize() int {
+	if len(api.miner.params.MaximumBlockSizes) == 0 {
+		return types.MaxBlockPayload
+	}
+	maxSize := 0
+	for _, size := range api.miner.params.MaximumBlockSizes {
+		if size > maxSize {
+			maxSize = size
+		}
+	}
+	return maxSize
+}
+
+// decodeSubmittedBlock decodes a hex-encoded serialized block as received by
+// the submitblock family of RPCs.  Oversized blocks are rejected before the hex is
+// decoded, so clients can not trigger arbitrarily large allocations.
+func (api *PublicMinerAPI) decodeSubmittedBlock(hexBlock string) (*types.SerializedBlock, error) {
+	if maxSize := api.maxSubmittedBlockSize(); len(hexBlock) > maxSize*2 {
+		return nil, rpc.RpcInvalidError("Block of %d bytes exceeds the "+
+			"maximum block size of %d bytes", (len(hexBlock)+1)/2, maxSize)
+	}
+	// A serialized block is always a whole number of bytes, so an odd
+	// number of hex characters means the client sent a truncated block.
 	if len(hexBlock)%2 != 0 {
-		hexBlock = "0" + hexBlock
+		return nil, rpc.RpcDecodeHexError(hexBlock)
 	}
 	serializedBlock, err := hex.DecodeString(hexBlock)
 
 	if err != nil {
 		return nil, rpc.RpcDecodeHexError(hexBlock)
 	}
+	return api.deserializeSubmittedBlock(serializedBlock)
+}
+
+// deserializeSubmittedBlock deserializes a block submitted as raw bytes after
+// ensuring it does not exceed the maximum block size.
+func (api *PublicMinerAPI) deserializeSubmittedBlock(serializedBlock []byte) (*types.SerializedBlock, error) {
+	if maxSize := api.maxSubmittedBlockSize(); len(serializedBlock) > maxSize {
+		return nil, rpc.RpcInvalidError("Block of %d bytes exceeds the "+
+			"maximum block size of %d bytes", len(serializedBlock), maxSize)
+	}
 	block, err := types.NewBlockFromBytes(serializedBlock)
 	if err != nil {
 		return nil, rpc.RpcDeserializationError("Block decode failed: %s", err.Error())
 	}
+	return block, nil
+}
+
+// staleParent returns the first parent of the passed block that is not one of
+// the passed current tips, or nil when all of its parents are tips.
+func staleParent(block *types.SerializedBlock, tips *blockdag.HashSet) *hash.Hash {
+	for _, parent := range block.Block().Parents {
+		if !tips.Has(parent) {
+			return parent
+		}
+	}
+	return nil
+}
+
+// processSubmittedBlock checks the tips referenced by a submitted block and
+// processes it using the same rules as blocks coming from other nodes.
+//
+// This function MUST be called with the submit block lock held.
+func (api *PublicMinerAPI) processSubmittedBlock(block *types.SerializedBlock) (*json.SubmitBlockResult, error) {
+	result := &json.SubmitBlockResult{BlockHash: block.Hash().String()}
+
+	// Templates are handed out asynchronously, so the tips a block was
+	// built on may have been extended in the meantime.  Reject blocks from
+	// such stale templates up front rather than with a deep rule error
+	// from connecting them.
+	if stale := staleParent(block, api.miner.blockManager.GetChain().BlockDAG().GetTips()); stale != nil {
+		result.RejectReason = rejectStaleTemplate
+		result.Message = fmt.Sprintf("Block submitted via miner is built "+
+			"on a stale template: parent %s is no longer a tip", stale)
+		return result, nil
+	}
 
-	// Because it's asynchronous, so you must ensure that all tips are referenced
 	parents := blockdag.NewIdSet()
 	for _, v := range block.Block().Parents {
 		parents.Add(api.miner.blockManager.GetChain().BlockIndex().GetDAGBlockID(v))
 	}
 	height, ok := api.miner.blockManager.GetChain().BlockDAG().CheckSubMainChainTip(parents.List())
 	if !ok {
-		return fmt.Sprintf("The tips of block is expired."), nil
+		result.RejectReason = rejectStaleParent
+		result.Message = "The tips of block is expired."
+		return result, nil
 	}
 	block.SetHeight(height)
 	// Process this block using the same rules as blocks coming from other
@@ -120,28 +474,46 @@ func (api *PublicMinerAPI) SubmitBlock(hexBlock string) (interface{}, error) {
 		// so log that error as an internal error.
 		rErr, ok := err.(blockchain.RuleError)
 		if !ok {
-			return fmt.Sprintf("Unexpected error while processing "+
-				"block submitted via miner: %s", err.Error()), nil
+			result.RejectReason = rejectUnexpected
+			result.Message = fmt.Sprintf("Unexpected error while processing "+
+				"block submitted via miner: %s", err.Error())
+			return result, nil
 		}
+		result.RejectReason = rejectReason(rErr.ErrorCode)
+
 		// Occasionally errors are given out for timing errors with
 		// ReduceMinDifficulty and high block works that is above
 		// the target. Feed these to debug.
 		if api.miner.params.ReduceMinDifficulty &&
 			rErr.ErrorCode == blockchain.ErrHighHash {
-			return fmt.Sprintf("Block submitted via miner rejected "+
-				"because of ReduceMinDifficulty time sync failure: %s", err.Error()), nil
+			result.Message = fmt.Sprintf("Block submitted via miner rejected "+
+				"because of ReduceMinDifficulty time sync failure: %s", err.Error())
+			return result, nil
 		}
 
 		if rErr.ErrorCode == blockchain.ErrDuplicateBlock {
-			return fmt.Sprintf(rErr.Description, err.Error()), nil
+			result.Message = rErr.Description
+			return result, nil
+		}
+		if rErr.ErrorCode == blockchain.ErrBadMerkleRoot {
+			calcRoot := merkle.CalcTxMerkleRoot(block.Transactions(), false)
+			result.HeaderMerkleRoot = block.Block().Header.TxRoot.String()
+			result.CalcMerkleRoot = calcRoot.String()
+			result.Message = fmt.Sprintf("Block submitted via miner rejected: "+
+				"header commits to merkle root %s, but the transactions "+
+				"hash to %s", result.HeaderMerkleRoot, result.CalcMerkleRoot)
+			return result, nil
 		}
 		// Other rule errors should be reported.
-		return fmt.Sprintf("Block submitted via miner rejected: %s", err.Error()), nil
+		result.Message = fmt.Sprintf("Block submitted via miner rejected: %s", err.Error())
+		return result, nil
 	}
 
 	if isOrphan {
-		return fmt.Sprintf("Block submitted via miner is an orphan building " +
-			"on parent"), nil
+		result.RejectReason = rejectOrphan
+		result.Message = "Block submitted via miner is an orphan building " +
+			"on parent"
+		return result, nil
 	}
 
 	// The block was accepted.
@@ -150,9 +522,14 @@ func (api *PublicMinerAPI) SubmitBlock(hexBlock string) (interface{}, error) {
 	for _, out := range coinbaseTxOuts {
 		coinbaseTxGenerated += out.Amount
 	}
-	return fmt.Sprintf("Block submitted accepted  hash %s, height %d, order %s amount %d", block.Hash().String(),
-		block.Height(), blockdag.GetOrderLogStr(uint(block.Order())), coinbaseTxGenerated), nil
-
+	api.miner.blockFound.notify(block)
+	result.Accepted = true
+	result.Height = uint64(block.Height())
+	result.Order = blockdag.GetOrderLogStr(uint(block.Order()))
+	result.Amount = coinbaseTxGenerated
+	result.Message = fmt.Sprintf("Block submitted accepted  hash %s, height %d, order %s amount %d", block.Hash().String(),
+		block.Height(), result.Order, coinbaseTxGenerated)
+	return result, nil
 }
 
 //LL
@@ -167,19 +544,13 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 	// either a coinbase value or a coinbase transaction object depending on
 	// the request.  Default to only providing a coinbase value.
 	useCoinbaseValue := true
+	powType := pow.QITMEERKECCAK256
 	if request != nil {
-		var hasCoinbaseValue, hasCoinbaseTxn bool
-		for _, capability := range request.Capabilities {
-			switch capability {
-			case "coinbasetxn":
-				hasCoinbaseTxn = true
-			case "coinbasevalue":
-				hasCoinbaseValue = true
-			}
-		}
-
-		if hasCoinbaseTxn && !hasCoinbaseValue {
-			useCoinbaseValue = false
+		powType = pow.PowType(request.PowType)
+		var err error
+		useCoinbaseValue, err = parseTemplateCapabilities(request.Capabilities)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -205,16 +576,75 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 	defer state.Unlock()
 
 	// Get and return a block template.  A new block template will be
-	// generated when the current best block has changed or the transactions
-	// in the memory pool have been updated and it has been at least five
-	// seconds since the last template was generated.  Otherwise, the
-	// timestamp for the existing block template is updated .
-	if err := state.updateBlockTemplate(api, useCoinbaseValue); err != nil {
+	// generated when the mining tips have changed or the transactions in
+	// the memory pool have been updated.  Otherwise, the timestamp for the
+	// existing block template is updated.
+	if err := state.updateBlockTemplate(api, useCoinbaseValue, powType); err != nil {
 		return nil, err
 	}
+
+	// Make sure the coinbase encodes the height the template is built for,
+	// so a coinbase construction regression is caught here rather than
+	// when the solved block is submitted.
+	if err := verifyTemplateCoinbaseHeight(state.template); err != nil {
+		return nil, rpc.RpcInternalError(err.Error(),
+			"Generated block template is invalid")
+	}
 	return state.blockTemplateResult(api, useCoinbaseValue, nil)
 }
 
+// verifyTemplateCoinbaseHeight ensures the height encoded in the coinbase of
+// the passed block template round-trips to the height of the template.
+func verifyTemplateCoinbaseHeight(template *types.BlockTemplate) error {
+	if len(template.Block.Transactions) == 0 {
+		return fmt.Errorf("block template has no coinbase")
+	}
+	height, err := blockchain.ExtractCoinbaseHeight(template.Block.Transactions[0])
+	if err != nil {
+		return err
+	}
+	if height != template.Height {
+		return fmt.Errorf("block template coinbase encodes height %d, "+
+			"expected %d", height, template.Height)
+	}
+	return nil
+}
+
+// parseTemplateCapabilities checks the capabilities passed to
+// getblocktemplate and reports whether the template should carry only the
+// coinbase value rather than the full coinbase transaction.  A capability
+// prefixed with '!' is required by the caller, so an error is returned when
+// such a capability is not one of gbtCapabilities.
+func parseTemplateCapabilities(capabilities []string) (bool, error) {
+	var hasCoinbaseTxn bool
+	for _, capability := range capabilities {
+		required := strings.HasPrefix(capability, "!")
+		capability = strings.TrimPrefix(capability, "!")
+		if capability == "coinbasetxn" {
+			hasCoinbaseTxn = true
+		}
+		if required && !isTemplateCapability(capability) {
+			return false, rpc.RpcInvalidError("Unsupported required "+
+				"capability %s", capability)
+		}
+	}
+
+	// The full coinbase transaction is only included when the caller is
+	// able to handle it, otherwise only the coinbase value is given.
+	return !hasCoinbaseTxn, nil
+}
+
+// isTemplateCapability returns whether the passed capability is one of the
+// capabilities advertised by getblocktemplate.
+func isTemplateCapability(capability string) bool {
+	for _, c := range gbtCapabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 //LL
 // encodeTemplateID encodes the passed details into an ID that can be used to
 // uniquely identify a block template.
@@ -226,52 +656,56 @@ func encodeTemplateID(prevHash hash.Hash, lastGenerated time.Time) string {
 // getblocktemplate.
 type gbtWorkState struct {
 	sync.Mutex
-	lastTxUpdate  time.Time
+	txGeneration  uint64
 	lastGenerated time.Time
 	parentsSet    *blockdag.HashSet
+	powType       pow.PowType
 	minTimestamp  time.Time
 	template      *types.BlockTemplate
 	timeSource    blockchain.MedianTimeSource
+
+	// result caches the reply built from template for the coinbase mode
+	// given by resultCoinbaseValue, so callers polling an unchanged
+	// template do not pay for serializing every transaction again.  It is
+	// cleared whenever a new template is generated.
+	result              *json.GetBlockTemplateResult
+	resultCoinbaseValue bool
 }
 
 // updateBlockTemplate creates or updates a block template for the work state.
-// A new block template will be generated when the current best block has
-// changed or the transactions in the memory pool have been updated and it has
-// been long enough since the last template was generated.  Otherwise, the
+// A new block template will be generated when the mining tips have changed or
+// the generation of the memory pool has changed.  Otherwise, the
 // timestamp for the existing block template is updated (and possibly the
 // difficulty on testnet per the consesus rules).  Finally, if the
 // useCoinbaseValue flag is false and the existing block template does not
 // already contain a valid payment address, the block template will be updated
 // with a randomly selected payment address from the list of configured
-// addresses.
+// addresses.  A template is also regenerated when it was built for a
+// different pow type than the requested one, since the difficulty differs.
 //
 // This function MUST be called with the state locked.
-func (state *gbtWorkState) updateBlockTemplate(api *PublicMinerAPI, useCoinbaseValue bool) error {
+func (state *gbtWorkState) updateBlockTemplate(api *PublicMinerAPI, useCoinbaseValue bool, powType pow.PowType) error {
 	m := api.miner
-	lastTxUpdate := m.txSource.LastUpdated()
-	if lastTxUpdate.IsZero() {
-		lastTxUpdate = time.Now()
-	}
+	txGeneration := m.txSource.Generation()
 
-	// Generate a new block template when the current best block has
-	// changed or the transactions in the memory pool have been updated and
-	// it has been at least gbtRegenerateSecond since the last template was
-	// generated.
+	// Generate a new block template when the mining tips have changed or
+	// the transactions in the memory pool have been updated.  The
+	// generation of the memory pool is compared rather than the time of its
+	// last update, which only has a resolution of a second.
 	var targetDifficulty string
 	rand.Seed(time.Now().UnixNano())
 	parentsSet := blockdag.NewHashSet()
 	parentsSet.AddList(m.blockManager.GetChain().GetMiningTips())
 	template := state.template
 	if template == nil || state.parentsSet == nil ||
-		!state.parentsSet.IsEqual(parentsSet) ||
-		(state.lastTxUpdate != lastTxUpdate &&
-			time.Now().After(state.lastGenerated.Add(time.Second*
-				gbtRegenerateSeconds))) {
+		!state.parentsSet.IsEqual(parentsSet) || state.powType != powType ||
+		state.txGeneration != txGeneration {
 
 		// Reset the previous best hash the block template was generated
 		// against so any errors below cause the next invocation to try
 		// again.
 		state.parentsSet = blockdag.NewHashSet()
+		state.result = nil
 
 		// Choose a payment address at random if the caller requests a
 		// full coinbase as opposed to only the pertinent details needed
@@ -287,7 +721,7 @@ func (state *gbtWorkState) updateBlockTemplate(api *PublicMinerAPI, useCoinbaseV
 		// block template doesn't include the coinbase, so the caller
 		// will ultimately create their own coinbase which pays to the
 		// appropriate address(es).
-		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, payToAddr, nil, pow.QITMEERKECCAK256)
+		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, payToAddr, nil, powType)
 		if err != nil {
 			return rpc.RpcInvalidError("Failed to create new block template: %s", err.Error())
 		}
@@ -304,8 +738,9 @@ func (state *gbtWorkState) updateBlockTemplate(api *PublicMinerAPI, useCoinbaseV
 		// generated until needed.
 		state.template = template
 		state.lastGenerated = time.Now()
-		state.lastTxUpdate = lastTxUpdate
+		state.txGeneration = txGeneration
 		state.parentsSet.AddList(msgBlock.Parents)
+		state.powType = powType
 		state.minTimestamp = minTimestamp
 
 		log.Debug(fmt.Sprintf("Generated block template (timestamp %v, "+
@@ -334,7 +769,9 @@ func (state *gbtWorkState) updateBlockTemplate(api *PublicMinerAPI, useCoinbaseV
 
 // blockTemplateResult returns the current block template associated with the
 // state as a GetBlockTemplateResult that is ready to be encoded to JSON
-// and returned to the caller.
+// and returned to the caller.  The reply is cached until a new template is
+// generated, so only the fields updateBlockTemplate may have changed are
+// refreshed for repeated requests.
 //
 // This function MUST be called with the state locked.
 func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseValue bool, submitOld *bool) (*json.GetBlockTemplateResult, error) {
@@ -351,6 +788,17 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 	if header.Timestamp.After(maxTime) {
 		return nil, rpc.RpcInvalidError("The template time is after the maximum allowed time for a block - template time %v, maximum time %v", adjustedTime, maxTime)
 	}
+	if state.result != nil && state.resultCoinbaseValue == useCoinbaseValue {
+		reply := *state.result
+		reply.CurTime = header.Timestamp.Unix()
+		reply.Bits = strconv.FormatInt(int64(header.Difficulty), 16)
+		reply.Target = fmt.Sprintf("%064x", pow.CompactToBig(header.Difficulty))
+		reply.MaxTime = maxTime.Unix()
+		reply.NonceRange = gbtNonceRange
+		reply.SubmitOld = submitOld
+		return &reply, nil
+	}
+
 	// Convert each transaction in the block template to a template result
 	// transaction.  The result does not include the coinbase, so notice
 	// the adjustments to the various lengths and indices.
@@ -409,12 +857,14 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 
 	//parents
 	parents := []json.GetBlockTemplateResultPt{}
+	parentHashes := make([]string, 0, len(template.Block.Parents))
 	for _, v := range template.Block.Parents {
 		resultPt := json.GetBlockTemplateResultPt{
 			Data: hex.EncodeToString(v.Bytes()),
 			Hash: v.String(),
 		}
 		parents = append(parents, resultPt)
+		parentHashes = append(parentHashes, v.String())
 	}
 	//TODO,submitOld
 
@@ -425,7 +875,6 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 	gbtMutableFields := []string{
 		"time", "transactions/add", "prevblock", "coinbase/append",
 	}
-	gbtCapabilities := []string{"proposal"}
 	blake2bdBig := pow.CompactToBig(template.PowDiffData.Blake2bDTarget)
 	x16rv3big := pow.CompactToBig(template.PowDiffData.X16rv3DTarget)
 	x8r16big := pow.CompactToBig(template.PowDiffData.X8r16DTarget)
@@ -450,6 +899,7 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 		//TODO，transactions
 		// make([]json.GetBlockTemplateResultTx, 0, 1)
 		Parents:      parents,
+		ParentHashes: parentHashes,
 		Transactions: transactions,
 		Version:      template.Block.Header.Version,
 		LongPollID:   longPollID,
@@ -471,6 +921,9 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 			CuckatooMinDiff:  targetCuckatooDDifficulty,
 			//cuckoo hash calc diff scale
 		},
+		PowType: uint8(state.powType),
+		Bits:    strconv.FormatInt(int64(header.Difficulty), 16),
+		Target:  fmt.Sprintf("%064x", pow.CompactToBig(header.Difficulty)),
 		MinTime: state.minTimestamp.Unix(),
 		MaxTime: maxTime.Unix(),
 		// gbtMutableFields
@@ -483,6 +936,8 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 	if useCoinbaseValue {
 		reply.CoinbaseAux = api.gbtCoinbaseAux
 		reply.CoinbaseValue = &msgBlock.Transactions[0].TxOut[0].Amount
+		reply.CoinbaseSubsidy, reply.CoinbaseRequiredOutputs =
+			coinbaseRequiredOutputs(msgBlock.Transactions[0], api.miner.params)
 	} else {
 		// Ensure the template has a valid payment address associated
 		// with it when a full coinbase is requested.
@@ -492,6 +947,12 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 				"been configured with any payment " +
 				"addresses via --miningaddr")
 		}
+		reply.CoinbaseAux = &json.GetBlockTemplateResultAux{
+			Flags:            api.gbtCoinbaseAux.Flags,
+			ExtraNonceOffset: template.ExtraNonceOffset,
+			ExtraNonceSize:   template.ExtraNonceSize,
+		}
+
 		// Serialize the transaction for conversion to hex.
 		tx := msgBlock.Transactions[0]
 		txBuf, err := tx.Serialize()
@@ -510,7 +971,29 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 
 		reply.CoinbaseTxn = &resultTx
 	}
-	return &reply, nil
+	state.result = &reply
+	state.resultCoinbaseValue = useCoinbaseValue
+	cached := reply
+	return &cached, nil
+}
+
+// coinbaseRequiredOutputs returns the total reward paid by the passed template
+// coinbase along with the outputs, other than the miner's own, that any
+// coinbase replacing it must keep for the block to be valid.
+func coinbaseRequiredOutputs(coinbase *types.Transaction, params *params.Params) (uint64, []json.GetBlockTemplateResultOutput) {
+	var subsidy uint64
+	for _, out := range coinbase.TxOut {
+		subsidy += out.Amount
+	}
+	if !params.HasTax() || len(coinbase.TxOut) <= blockchain.CoinbaseOutput_tax {
+		return subsidy, nil
+	}
+	tax := coinbase.TxOut[blockchain.CoinbaseOutput_tax]
+	return subsidy, []json.GetBlockTemplateResultOutput{{
+		Index:    blockchain.CoinbaseOutput_tax,
+		Amount:   tax.Amount,
+		PkScript: hex.EncodeToString(tax.PkScript),
+	}}
 }
 
 // PrivateMinerAPI provides private RPC methods to control the miner.
@@ -536,8 +1019,9 @@ func (api *PrivateMinerAPI) Generate(numBlocks uint32, powType pow.PowType) ([]s
 		return nil, rpc.RpcInternalError("Invalid number of blocks",
 			"Configuration")
 	}
-	if numBlocks > 3000 {
-		return nil, fmt.Errorf("error, more than 1000")
+	if numBlocks > maxGenerateBlocks {
+		return nil, rpc.RpcInvalidError("Cannot generate more than %d "+
+			"blocks", maxGenerateBlocks)
 	}
 	blockHashes, err := api.miner.GenerateNBlocks(numBlocks, powType)
 	if err != nil {
@@ -556,6 +1040,92 @@ func (api *PrivateMinerAPI) Generate(numBlocks uint32, powType pow.PowType) ([]s
 	return reply, nil
 }
 
+// GenerateToAddress mines the requested number of blocks like Generate, but
+// pays the coinbase of every block to the passed address instead of the
+// configured mining addresses.
+func (api *PrivateMinerAPI) GenerateToAddress(numBlocks uint32, addr string, powType pow.PowType) ([]string, error) {
+	payToAddr, err := address.DecodeAddress(addr)
+	if err != nil {
+		return nil, rpc.RpcAddressKeyError("Invalid address: %v", err)
+	}
+	if !address.IsForNetwork(payToAddr, api.miner.params) {
+		return nil, rpc.RpcAddressKeyError("Address %s is not for the "+
+			"%s network", addr, api.miner.params.Name)
+	}
+
+	// Respond with an error if the client is requesting 0 blocks to be generated.
+	if numBlocks == 0 {
+		return nil, rpc.RpcInternalError("Invalid number of blocks",
+			"Configuration")
+	}
+	if numBlocks > maxGenerateBlocks {
+		return nil, rpc.RpcInvalidError("Cannot generate more than %d "+
+			"blocks", maxGenerateBlocks)
+	}
+	blockHashes, err := api.miner.GenerateNBlocksToAddress(numBlocks, powType, payToAddr)
+	if err != nil {
+		return nil, rpc.RpcInternalError("Could not generate blocks,"+err.Error(),
+			"miner")
+	}
+	reply := make([]string, numBlocks)
+	for i, hash := range blockHashes {
+		reply[i] = hash.String()
+	}
+	return reply, nil
+}
+
+// SetGenerate starts or stops continuous background mining.  Unlike Generate,
+// which mines a fixed number of blocks, the miner keeps solving blocks of the
+// passed pow type until it is stopped.  A workers value of zero or below uses
+// the default number of workers.
+func (api *PrivateMinerAPI) SetGenerate(enable bool, powType pow.PowType, workers int) error {
+	if !enable {
+		api.miner.Stop()
+		return nil
+	}
+
+	// Respond with an error if there are no addresses to pay the
+	// created blocks to.
+	if len(api.miner.config.GetMinningAddrs()) == 0 {
+		return rpc.RpcInternalError("No payment addresses specified "+
+			"via --miningaddr", "Configuration")
+	}
+	if _, ok := pow.PowMapString[powType]; !ok {
+		return rpc.RpcInvalidError("Unknown pow type %d", powType)
+	}
+	if !cpuSolvable(powType) {
+		return rpc.RpcInvalidError("The CPU miner can not mine pow type "+
+			"%s", pow.PowMapString[powType])
+	}
+
+	api.miner.SetPowType(powType)
+	if workers <= 0 {
+		workers = -1
+	}
+	api.miner.SetNumWorkers(int32(workers))
+	api.miner.Start()
+	if !api.miner.IsMining() {
+		return rpc.RpcInternalError("Could not start the CPU miner",
+			"miner")
+	}
+	return nil
+}
+
+// GetGenerate returns whether or not the server is set to mine continuously.
+func (api *PrivateMinerAPI) GetGenerate() (bool, error) {
+	return api.miner.IsMining(), nil
+}
+
+// GetMiningStats returns the current hash rate of the CPU miner along with its
+// breakdown by the pow types mined since it was started.
+func (api *PrivateMinerAPI) GetMiningStats() (*json.MiningStatsResult, error) {
+	return &json.MiningStatsResult{
+		Mining:          api.miner.IsMining(),
+		HashesPerSecond: api.miner.HashesPerSecond(),
+		HashrateByPow:   api.miner.HashrateByPow(),
+	}, nil
+}
+
 func builderScript(builder *txscript.ScriptBuilder) []byte {
 	script, err := builder.Script()
 	if err != nil {
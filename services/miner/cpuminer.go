@@ -71,15 +71,21 @@ type CPUMiner struct {
 	numWorkers        uint32
 	started           bool
 	discreteMining    bool
+	powType           pow.PowType
 	submitBlockLock   sync.Mutex
 	wg                sync.WaitGroup
 	workerWg          sync.WaitGroup
 	updateNumWorkers  chan struct{}
 	queryHashesPerSec chan float64
-	updateHashes      chan uint64
+	queryPowHashrate  chan map[pow.PowType]float64
+	updateHashes      chan hashUpdate
 	speedMonitorQuit  chan struct{}
 	quit              chan struct{}
 
+	// blockFound notifies subscribers about accepted blocks found by
+	// this node.
+	blockFound blockFoundFeed
+
 	// This is a map that keeps track of how many blocks have
 	// been mined on each parent by the CPUMiner. It is only
 	// for use in simulation networks, to diminish memory
@@ -103,9 +109,11 @@ func NewCPUMiner(cfg *config.Config, par *params.Params, policy *mining.Policy,
 		timeSource:        tsource,
 		blockManager:      blkMgr,
 		numWorkers:        numWorkers,
+		powType:           pow.QITMEERKECCAK256,
 		updateNumWorkers:  make(chan struct{}),
 		queryHashesPerSec: make(chan float64),
-		updateHashes:      make(chan uint64),
+		queryPowHashrate:  make(chan map[pow.PowType]float64),
+		updateHashes:      make(chan hashUpdate),
 		minedOnParents:    make(map[hash.Hash]uint8),
 	}
 }
@@ -116,6 +124,19 @@ func NewCPUMiner(cfg *config.Config, par *params.Params, policy *mining.Policy,
 // generating a new block template.  When a block is solved, it is submitted.
 // The function returns a list of the hashes of generated blocks.
 func (m *CPUMiner) GenerateNBlocks(n uint32, powType pow.PowType) ([]*hash.Hash, error) {
+	return m.generateNBlocks(n, powType, nil)
+}
+
+// GenerateNBlocksToAddress is like GenerateNBlocks except the coinbase of every
+// generated block pays to the passed address instead of a randomly chosen
+// configured mining address.
+func (m *CPUMiner) GenerateNBlocksToAddress(n uint32, powType pow.PowType, payToAddr types.Address) ([]*hash.Hash, error) {
+	return m.generateNBlocks(n, powType, payToAddr)
+}
+
+// generateNBlocks implements GenerateNBlocks and GenerateNBlocksToAddress.  A
+// nil payToAddr picks a configured mining address at random for each block.
+func (m *CPUMiner) generateNBlocks(n uint32, powType pow.PowType, payToAddr types.Address) ([]*hash.Hash, error) {
 	m.Lock()
 
 	// Respond with an error if there's virtually 0 chance of CPU-mining a block.
@@ -166,15 +187,19 @@ func (m *CPUMiner) GenerateNBlocks(n uint32, powType pow.PowType) ([]*hash.Hash,
 		// template on a block that is in the process of becoming stale.
 		m.submitBlockLock.Lock()
 
-		// Choose a payment address at random.
-		rand.Seed(time.Now().UnixNano())
-		payToAddr := m.config.GetMinningAddrs()[rand.Intn(len(m.config.GetMinningAddrs()))]
+		// Choose a payment address at random unless the caller asked
+		// for a specific one.
+		addr := payToAddr
+		if addr == nil {
+			rand.Seed(time.Now().UnixNano())
+			addr = m.config.GetMinningAddrs()[rand.Intn(len(m.config.GetMinningAddrs()))]
+		}
 
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
 		// include in the block.
 		// TODO, refactor NewBlockTemplate input dependencies
-		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, payToAddr, nil, powType)
+		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, addr, nil, powType)
 		m.submitBlockLock.Unlock()
 		if err != nil {
 			errStr := fmt.Sprintf("template: %v", err)
@@ -193,31 +218,15 @@ func (m *CPUMiner) GenerateNBlocks(n uint32, powType pow.PowType) ([]*hash.Hash,
 			continue //might try again?
 		}
 
-		var result = false
-		switch powType {
-		case pow.BLAKE2BD:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.Blake2bDTarget)
-			result = m.solveBlock(template.Block, ticker, nil)
-		case pow.X16RV3:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.X16rv3DTarget)
-			result = m.solveX16rv3Block(template.Block, ticker, nil)
-		case pow.X8R16:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.X8r16DTarget)
-			result = m.solveX8r16Block(template.Block, ticker, nil)
-		case pow.QITMEERKECCAK256:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.QitmeerKeccak256Target)
-			result = m.solveQitmeerKeccak256Block(template.Block, ticker, nil)
-		case pow.CUCKAROO:
-			template.Block.Header.Difficulty = pow.BigToCompact(new(big.Int).SetUint64(template.PowDiffData.CuckarooBaseDiff))
-			result = m.solveCuckarooBlock(template.Block, ticker, nil, template.PowDiffData.CuckarooDiffScale, template.Height)
-		default:
+		result, err := m.solveTemplate(template, powType, ticker, nil)
+		if err != nil {
 			m.Lock()
 			close(m.speedMonitorQuit)
 			m.wg.Wait()
 			m.started = false
 			m.discreteMining = false
 			m.Unlock()
-			return nil, errors.New("pow not found!") //should miner if error
+			return nil, err //should miner if error
 		}
 
 		// Attempt to solve the block.  The function will exit early
@@ -252,6 +261,13 @@ func (m *CPUMiner) GenerateNBlocks(n uint32, powType pow.PowType) ([]*hash.Hash,
 	}
 }
 
+// hashUpdate is sent by the solvers to the speed monitor to report the number
+// of hashes completed for the pow type being mined.
+type hashUpdate struct {
+	powType pow.PowType
+	hashes  uint64
+}
+
 // speedMonitor handles tracking the number of hashes per second the mining
 // process is performing.  It must be run as a goroutine.
 func (m *CPUMiner) speedMonitor() {
@@ -259,6 +275,8 @@ func (m *CPUMiner) speedMonitor() {
 
 	var hashesPerSec float64
 	var totalHashes uint64
+	hashesPerSecByPow := make(map[pow.PowType]float64)
+	totalHashesByPow := make(map[pow.PowType]uint64)
 	ticker := time.NewTicker(time.Second * hpsUpdateSecs)
 	defer ticker.Stop()
 
@@ -267,8 +285,9 @@ out:
 		select {
 		// Periodic updates from the workers with how many hashes they
 		// have performed.
-		case numHashes := <-m.updateHashes:
-			totalHashes += numHashes
+		case update := <-m.updateHashes:
+			totalHashes += update.hashes
+			totalHashesByPow[update.powType] += update.hashes
 
 		// Time to update the hashes per second.
 		case <-ticker.C:
@@ -278,6 +297,16 @@ out:
 			}
 			hashesPerSec = (hashesPerSec + curHashesPerSec) / 2
 			totalHashes = 0
+			for powType, hashes := range totalHashesByPow {
+				if _, ok := hashesPerSecByPow[powType]; !ok {
+					hashesPerSecByPow[powType] = float64(hashes) / hpsUpdateSecs
+				}
+			}
+			for powType, rate := range hashesPerSecByPow {
+				cur := float64(totalHashesByPow[powType]) / hpsUpdateSecs
+				hashesPerSecByPow[powType] = (rate + cur) / 2
+			}
+			totalHashesByPow = make(map[pow.PowType]uint64)
 			if hashesPerSec != 0 {
 				log.Debug(fmt.Sprintf("Hash speed: %6.0f kilohashes/s",
 					hashesPerSec/1000))
@@ -287,6 +316,10 @@ out:
 		case m.queryHashesPerSec <- hashesPerSec:
 			// Nothing to do.
 
+		// Request for the hashes per second of each pow type.
+		case m.queryPowHashrate <- copyHashrates(hashesPerSecByPow):
+			// Nothing to do.
+
 		case <-m.speedMonitorQuit:
 			break out
 		}
@@ -296,6 +329,16 @@ out:
 	log.Trace("CPU miner speed monitor done")
 }
 
+// copyHashrates returns a copy of the passed per pow type hash rates so they can
+// be handed to another goroutine.
+func copyHashrates(rates map[pow.PowType]float64) map[pow.PowType]float64 {
+	ratesCopy := make(map[pow.PowType]float64, len(rates))
+	for powType, rate := range rates {
+		ratesCopy[powType] = rate
+	}
+	return ratesCopy
+}
+
 // solveBlock attempts to find some combination of a nonce, extra nonce, and
 // current timestamp which makes the passed block hash to a value less than the
 // target difficulty.  The timestamp is updated periodically and the passed
@@ -352,7 +395,7 @@ func (m *CPUMiner) solveBlock(msgBlock *types.Block, ticker *time.Ticker, quit c
 			return false
 
 		case <-ticker.C:
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.BLAKE2BD, hashesCompleted}
 			hashesCompleted = 0
 
 			// The current block is stale if the memory pool
@@ -392,7 +435,7 @@ func (m *CPUMiner) solveBlock(msgBlock *types.Block, ticker *time.Ticker, quit c
 		if hashNum.Cmp(target) <= 0 {
 			// The block is solved when the new block hash is less
 			// than the target difficulty.  Yay!
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.BLAKE2BD, hashesCompleted}
 			return true
 		}
 	}
@@ -439,13 +482,48 @@ func (m *CPUMiner) solveCuckarooBlock(msgBlock *types.Block, ticker *time.Ticker
 		hashesCompleted += 2
 		targetDiff := pow.CompactToBig(header.Difficulty)
 		if pow.CalcCuckooDiff(powStruct.GraphWeight(), header.BlockHash()).Cmp(targetDiff) >= 0 {
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.CUCKAROO, hashesCompleted}
 			return true
 		}
 	}
 	return false
 }
 
+// cpuSolvable returns whether solveTemplate has a CPU solver for the passed
+// pow type.
+func cpuSolvable(powType pow.PowType) bool {
+	switch powType {
+	case pow.BLAKE2BD, pow.X16RV3, pow.X8R16, pow.QITMEERKECCAK256,
+		pow.CUCKAROO:
+		return true
+	}
+	return false
+}
+
+// solveTemplate sets the difficulty of the template's header for the passed
+// pow type and attempts to solve it with the matching solver.  An error is
+// returned when there is no CPU solver for the pow type.
+func (m *CPUMiner) solveTemplate(template *types.BlockTemplate, powType pow.PowType, ticker *time.Ticker, quit chan struct{}) (bool, error) {
+	switch powType {
+	case pow.BLAKE2BD:
+		template.Block.Header.Difficulty = uint32(template.PowDiffData.Blake2bDTarget)
+		return m.solveBlock(template.Block, ticker, quit), nil
+	case pow.X16RV3:
+		template.Block.Header.Difficulty = uint32(template.PowDiffData.X16rv3DTarget)
+		return m.solveX16rv3Block(template.Block, ticker, quit), nil
+	case pow.X8R16:
+		template.Block.Header.Difficulty = uint32(template.PowDiffData.X8r16DTarget)
+		return m.solveX8r16Block(template.Block, ticker, quit), nil
+	case pow.QITMEERKECCAK256:
+		template.Block.Header.Difficulty = uint32(template.PowDiffData.QitmeerKeccak256Target)
+		return m.solveQitmeerKeccak256Block(template.Block, ticker, quit), nil
+	case pow.CUCKAROO:
+		template.Block.Header.Difficulty = pow.BigToCompact(new(big.Int).SetUint64(template.PowDiffData.CuckarooBaseDiff))
+		return m.solveCuckarooBlock(template.Block, ticker, quit, template.PowDiffData.CuckarooDiffScale, template.Height), nil
+	}
+	return false, errors.New("pow not found!")
+}
+
 // submitBlock submits the passed block to network after ensuring it passes all
 // of the consensus validation rules.
 func (m *CPUMiner) submitBlock(block *types.SerializedBlock) bool {
@@ -492,6 +570,7 @@ func (m *CPUMiner) submitBlock(block *types.SerializedBlock) bool {
 	}
 	log.Info("Block submitted accepted", "hash", block.Hash(),
 		"order", blockdag.GetOrderLogStr(uint(block.Order())), "height", block.Height(), "amount", coinbaseTxGenerated)
+	m.blockFound.notify(block)
 	return true
 }
 
@@ -633,6 +712,30 @@ func (m *CPUMiner) HashesPerSecond() float64 {
 	return <-m.queryHashesPerSec
 }
 
+// HashrateByPow returns the number of hashes per second the mining process is
+// performing for each pow type it has mined since it was started, keyed by the
+// pow type name.  Nil is returned if the miner is not currently running.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) HashrateByPow() map[string]float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	// Nothing to do if the miner is not currently running.
+	if !m.started {
+		return nil
+	}
+
+	rates := <-m.queryPowHashrate
+	result := make(map[string]float64, len(rates))
+	for powType, rate := range rates {
+		if name, ok := pow.PowMapString[powType].(string); ok {
+			result[name] = rate
+		}
+	}
+	return result
+}
+
 // SetNumWorkers sets the number of workers to create which solve blocks.  Any
 // negative values will cause a default number of workers to be used which is
 // based on the number of processor cores in the system.  A value of 0 will
@@ -663,6 +766,28 @@ func (m *CPUMiner) SetNumWorkers(numWorkers int32) {
 	}
 }
 
+// SetPowType sets the pow type the background mining workers solve blocks
+// for.  It is picked up by each worker when it creates its next block
+// template, so the setting persists across template refreshes.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) SetPowType(powType pow.PowType) {
+	m.Lock()
+	m.powType = powType
+	m.Unlock()
+}
+
+// PowType returns the pow type the background mining workers solve blocks
+// for.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) PowType() pow.PowType {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.powType
+}
+
 // NumWorkers returns the number of workers which are running to solve blocks.
 //
 // This function is safe for concurrent access.
@@ -721,7 +846,8 @@ out:
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
 		// include in the block.
-		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, payToAddr, nil, pow.QITMEERKECCAK256)
+		powType := m.PowType()
+		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, payToAddr, nil, powType)
 		m.submitBlockLock.Unlock()
 		if err != nil {
 			errStr := fmt.Sprintf("template: %v", err)
@@ -750,7 +876,12 @@ out:
 		// with false when conditions that trigger a stale block, so
 		// a new block template can be generated.  When the return is
 		// true a solution was found, so submit the solved block.
-		if m.solveBlock(template.Block, ticker, quit) {
+		solved, err := m.solveTemplate(template, powType, ticker, quit)
+		if err != nil {
+			log.Error("Failed to solve new block ", "err", err)
+			continue
+		}
+		if solved {
 			block := types.NewBlock(template.Block)
 			block.SetHeight(uint(template.Height))
 			if !m.submitBlock(block) {
@@ -789,8 +920,7 @@ func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, extraNonce uint64) er
 
 	// Recalculate the merkle root with the updated extra nonce.
 	block := types.NewBlock(msgBlock)
-	merkles := merkle.BuildMerkleTreeStore(block.Transactions(), false)
-	msgBlock.Header.TxRoot = *merkles[len(merkles)-1]
+	msgBlock.Header.TxRoot = merkle.CalcTxMerkleRoot(block.Transactions(), false)
 	return nil
 }
 
@@ -0,0 +1,168 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package miner
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"sync"
+)
+
+// maxGetWorkBlocks is the maximum number of blocks handed out by getwork that
+// are remembered for submitwork.  The oldest one is forgotten first.
+const maxGetWorkBlocks = 100
+
+// getWorkState houses the blocks handed out by getwork so a solved header
+// submitted via submitwork can be matched back to its transactions.  Blocks
+// are keyed by their transaction merkle root which the solver does not change.
+type getWorkState struct {
+	sync.Mutex
+	parentRoot hash.Hash
+	blocks     map[hash.Hash]*types.Block
+	txRoots    []hash.Hash
+
+	// templates holds the block template state of getwork for each pow
+	// type.  It is kept apart from the state of getblocktemplate, so
+	// callers asking for different pow types don't force each other's
+	// templates to be regenerated.
+	templates map[pow.PowType]*gbtWorkState
+}
+
+// templateState returns the block template state of getwork for the passed pow
+// type, creating it as needed.
+//
+// This function is safe for concurrent access.
+func (work *getWorkState) templateState(api *PublicMinerAPI, powType pow.PowType) *gbtWorkState {
+	work.Lock()
+	defer work.Unlock()
+
+	state, ok := work.templates[powType]
+	if !ok {
+		state = &gbtWorkState{timeSource: api.miner.timeSource}
+		work.templates[powType] = state
+	}
+	return state
+}
+
+// addBlock remembers the passed block handed out by getwork.  Work handed out
+// against other parents is stale, so it is forgotten, as is the oldest block
+// once maxGetWorkBlocks blocks are remembered.
+//
+// This function MUST be called with the state locked.
+func (work *getWorkState) addBlock(msgBlock *types.Block) {
+	if !work.parentRoot.IsEqual(&msgBlock.Header.ParentRoot) {
+		work.parentRoot = msgBlock.Header.ParentRoot
+		work.blocks = make(map[hash.Hash]*types.Block)
+		work.txRoots = nil
+	}
+	txRoot := msgBlock.Header.TxRoot
+	if _, ok := work.blocks[txRoot]; !ok {
+		if len(work.txRoots) >= maxGetWorkBlocks {
+			delete(work.blocks, work.txRoots[0])
+			work.txRoots = work.txRoots[1:]
+		}
+		work.txRoots = append(work.txRoots, txRoot)
+	}
+	work.blocks[txRoot] = &types.Block{
+		Parents:      msgBlock.Parents,
+		Transactions: msgBlock.Transactions,
+	}
+}
+
+// GetWork returns the header of a block template for the passed pow type in
+// the compact format used by legacy getwork miners.  The miner only varies the
+// nonce and timestamp in the header and hands it back via SubmitWork.
+func (api *PublicMinerAPI) GetWork(powType pow.PowType) (*json.GetWorkResult, error) {
+	if _, ok := pow.PowMapString[powType]; !ok {
+		return nil, rpc.RpcInvalidError("Invalid pow type %d", powType)
+	}
+
+	// Getwork miners can't build their own coinbase, so the template must
+	// pay to one of the configured addresses.
+	if len(api.miner.config.GetMinningAddrs()) == 0 {
+		return nil, rpc.RpcInternalError("No payment addresses specified ",
+			"getwork requires the server to be configured with "+
+				"payment addresses via --miningaddr")
+	}
+	currentOrder := api.miner.blockManager.GetChain().BestSnapshot().GraphState.GetTotal() - 1
+	if currentOrder != 0 && !api.miner.blockManager.IsCurrent() {
+		return nil, rpc.RPCClientInInitialDownloadError("Client in initial download ",
+			"qitmeer is downloading blocks...")
+	}
+
+	state := api.getWorkState.templateState(api, powType)
+	state.Lock()
+	defer state.Unlock()
+	if err := state.updateBlockTemplate(api, false, powType); err != nil {
+		return nil, err
+	}
+	msgBlock := state.template.Block
+
+	var buf bytes.Buffer
+	if err := msgBlock.Header.Serialize(&buf); err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to serialize header")
+	}
+
+	// Remember the block so the solved header can be reassembled.
+	work := api.getWorkState
+	work.Lock()
+	work.addBlock(msgBlock)
+	work.Unlock()
+
+	return &json.GetWorkResult{
+		Data:    hex.EncodeToString(buf.Bytes()),
+		Target:  fmt.Sprintf("%064x", pow.CompactToBig(msgBlock.Header.Difficulty)),
+		PowType: uint8(powType),
+	}, nil
+}
+
+// SubmitWork reassembles the block for a header solved from GetWork and
+// submits it.  It returns whether the block was accepted.
+func (api *PublicMinerAPI) SubmitWork(data string) (bool, error) {
+	if len(data)%2 != 0 {
+		return false, rpc.RpcDecodeHexError(data)
+	}
+	serialized, err := hex.DecodeString(data)
+	if err != nil {
+		return false, rpc.RpcDecodeHexError(data)
+	}
+	var header types.BlockHeader
+	if err := header.Deserialize(bytes.NewReader(serialized)); err != nil {
+		return false, rpc.RpcDeserializationError("Header decode failed: %s", err.Error())
+	}
+
+	work := api.getWorkState
+	work.Lock()
+	tmpl, ok := work.blocks[header.TxRoot]
+	stale := !work.parentRoot.IsEqual(&header.ParentRoot)
+	work.Unlock()
+	if !ok || stale {
+		log.Debug(fmt.Sprintf("Rejecting submitted work for unknown or "+
+			"stale template (merkle root %s)", header.TxRoot))
+		return false, nil
+	}
+
+	block := types.NewBlock(&types.Block{
+		Header:       header,
+		Parents:      tmpl.Parents,
+		Transactions: tmpl.Transactions,
+	})
+
+	m := api.miner
+	m.submitBlockLock.Lock()
+	result, err := api.processSubmittedBlock(block)
+	m.submitBlockLock.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if !result.Accepted {
+		log.Debug(fmt.Sprintf("Submitted work rejected: %s", result.Message))
+	}
+	return result.Accepted, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package miner
+
+import (
+	"context"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"sync"
+)
+
+// blockFoundFeedSize is the number of pending notifications buffered for each
+// subscriber.  Notifications for a subscriber which falls further behind are
+// dropped rather than stalling block submission.
+const blockFoundFeedSize = 16
+
+// blockFoundFeed fans out notifications about accepted blocks found by this
+// node to all subscribers.
+type blockFoundFeed struct {
+	sync.Mutex
+	subs map[chan *json.BlockFoundNtfn]struct{}
+}
+
+// subscribe registers and returns a new subscriber channel.
+func (f *blockFoundFeed) subscribe() chan *json.BlockFoundNtfn {
+	ch := make(chan *json.BlockFoundNtfn, blockFoundFeedSize)
+	f.Lock()
+	if f.subs == nil {
+		f.subs = make(map[chan *json.BlockFoundNtfn]struct{})
+	}
+	f.subs[ch] = struct{}{}
+	f.Unlock()
+	return ch
+}
+
+// unsubscribe removes the passed subscriber channel.
+func (f *blockFoundFeed) unsubscribe(ch chan *json.BlockFoundNtfn) {
+	f.Lock()
+	delete(f.subs, ch)
+	f.Unlock()
+}
+
+// notify sends a notification for the passed accepted block to every
+// subscriber without blocking.
+func (f *blockFoundFeed) notify(block *types.SerializedBlock) {
+	powType := block.Block().Header.Pow.GetPowType()
+	ntfn := &json.BlockFoundNtfn{
+		Hash:    block.Hash().String(),
+		Height:  uint64(block.Height()),
+		PowType: pow.PowMapString[powType].(string),
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- ntfn:
+		default:
+			log.Debug("Dropping block found notification for slow subscriber",
+				"hash", ntfn.Hash)
+		}
+	}
+}
+
+// BlockFound creates a subscription which is notified with the hash, height and
+// pow type of every block found by the CPU miner or submitted through the
+// miner RPCs once it has been accepted.
+func (api *PrivateMinerAPI) BlockFound(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	ch := api.miner.blockFound.subscribe()
+
+	go func() {
+		defer api.miner.blockFound.unsubscribe(ch)
+		for {
+			select {
+			case ntfn := <-ch:
+				if err := notifier.Notify(sub.ID, ntfn); err != nil {
+					return
+				}
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -24,7 +24,7 @@ func (m *CPUMiner) solveQitmeerKeccak256Block(msgBlock *types.Block, ticker *tim
 			return false
 
 		case <-ticker.C:
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.QITMEERKECCAK256, hashesCompleted}
 			hashesCompleted = 0
 
 			// The current block is stale if the memory pool
@@ -65,7 +65,7 @@ func (m *CPUMiner) solveQitmeerKeccak256Block(msgBlock *types.Block, ticker *tim
 		if hashNum.Cmp(target) <= 0 {
 			// The block is solved when the new block hash is less
 			// than the target difficulty.  Yay!
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.QITMEERKECCAK256, hashesCompleted}
 			return true
 		}
 	}
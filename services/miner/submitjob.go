@@ -0,0 +1,90 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package miner
+
+import (
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/satori/go.uuid"
+	"sync"
+	"time"
+)
+
+const (
+	// submitJobPending and submitJobDone are the states reported for a
+	// block submitted via submitblockasync.
+	submitJobPending = "pending"
+	submitJobDone    = "done"
+
+	// submitJobExpiry is how long the result of a finished submit job is
+	// kept before it is discarded.
+	submitJobExpiry = time.Minute * 10
+)
+
+// submitJob tracks a single block submitted via submitblockasync.
+type submitJob struct {
+	id       string
+	done     bool
+	result   interface{}
+	err      error
+	finished time.Time
+}
+
+// submitJobs houses the submit jobs that are either still being processed or
+// whose result has not yet expired.
+type submitJobs struct {
+	sync.Mutex
+	jobs map[string]*submitJob
+}
+
+func newSubmitJobs() *submitJobs {
+	return &submitJobs{jobs: make(map[string]*submitJob)}
+}
+
+// newJob registers and returns a new pending job.  Expired jobs are pruned at
+// the same time so the set does not grow without bound.
+func (s *submitJobs) newJob() *submitJob {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for id, job := range s.jobs {
+		if job.done && now.Sub(job.finished) > submitJobExpiry {
+			delete(s.jobs, id)
+		}
+	}
+
+	job := &submitJob{id: uuid.NewV4().String()}
+	s.jobs[job.id] = job
+	return job
+}
+
+// finish records the outcome of processing the job's block.
+func (s *submitJobs) finish(job *submitJob, result interface{}, err error) {
+	s.Lock()
+	job.done = true
+	job.result = result
+	job.err = err
+	job.finished = time.Now()
+	s.Unlock()
+}
+
+// lookup returns the current state of the job with the passed id, or nil when
+// the id is unknown or its result has expired.
+func (s *submitJobs) lookup(id string) *json.SubmitBlockJobResult {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	result := &json.SubmitBlockJobResult{JobID: job.id, Status: submitJobPending}
+	if job.done {
+		result.Status = submitJobDone
+		result.Result = job.result
+		if job.err != nil {
+			result.Error = job.err.Error()
+		}
+	}
+	return result
+}
@@ -55,7 +55,7 @@ func (m *CPUMiner) solveX16rv3Block(msgBlock *types.Block, ticker *time.Ticker,
 			return false
 
 		case <-ticker.C:
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.X16RV3, hashesCompleted}
 			hashesCompleted = 0
 
 			// The current block is stale if the memory pool
@@ -95,7 +95,7 @@ func (m *CPUMiner) solveX16rv3Block(msgBlock *types.Block, ticker *time.Ticker,
 		if hashNum.Cmp(target) <= 0 {
 			// The block is solved when the new block hash is less
 			// than the target difficulty.  Yay!
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.X16RV3, hashesCompleted}
 			return true
 		}
 	}
@@ -34,6 +34,20 @@ const (
 	// TODO, refactor the location of coinbaseFlags const
 	CoinbaseFlags = "/qitmeer/"
 
+	// DefaultCoinbaseExtraNonceSize is the default size in bytes of the
+	// extra nonce region reserved in the coinbase signature script.
+	DefaultCoinbaseExtraNonceSize = 8
+
+	// MinCoinbaseExtraNonceSize is the minimum size in bytes of the extra
+	// nonce region.  A single byte could be pushed as a small integer
+	// opcode instead of as data, which would leave no region to overwrite.
+	MinCoinbaseExtraNonceSize = 2
+
+	// MaxCoinbaseExtraNonceSize is the maximum size in bytes of the extra
+	// nonce region, which keeps the coinbase signature script well below
+	// blockchain.MaxCoinbaseScriptLen.
+	MaxCoinbaseExtraNonceSize = 32
+
 	// generatedBlockVersion is the version of the block being generated for
 	// the main network.  It is defined as a constant here rather than using
 	// the wire.BlockVersion constant since a change in the block version
@@ -64,6 +78,10 @@ type TxSource interface {
 	// removed from the source pool.
 	LastUpdated() time.Time
 
+	// Generation returns a counter that changes every time a transaction
+	// is added to or removed from the source pool.
+	Generation() uint64
+
 	// MiningDescs returns a slice of mining descriptors for all the
 	// transactions in the source pool.
 	MiningDescs() []*types.TxDesc
@@ -93,12 +111,29 @@ func MedianAdjustedTime(bc *blockchain.BlockChain, timeSource blockchain.MedianT
 	return newTimestamp
 }
 
-func standardCoinbaseScript(nextBlockHeight uint64, extraNonce uint64) ([]byte, error) {
+// standardCoinbaseScript returns a coinbase signature script made up of pushes
+// of the block height, the extra nonce and the coinbase flags.  The extra nonce
+// is pushed as data, so it always takes its full size and starts at the offset
+// returned by coinbaseExtraNonceOffset.
+func standardCoinbaseScript(nextBlockHeight uint64, extraNonce []byte) ([]byte, error) {
 	return txscript.NewScriptBuilder().AddInt64(int64(nextBlockHeight)).
-		AddInt64(int64(extraNonce)).AddData([]byte(CoinbaseFlags)).
+		AddData(extraNonce).AddData([]byte(CoinbaseFlags)).
 		Script()
 }
 
+// coinbaseExtraNonceOffset returns the offset of the extra nonce within the
+// coinbase signature script standardCoinbaseScript returns for the passed
+// height, which is right after the height push and the data push opcode of the
+// extra nonce.
+func coinbaseExtraNonceOffset(nextBlockHeight uint64) (int, error) {
+	heightScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(nextBlockHeight)).Script()
+	if err != nil {
+		return 0, err
+	}
+	return len(heightScript) + 1, nil
+}
+
 // standardCoinbaseOpReturn creates a standard OP_RETURN output to insert into
 // coinbase to use as extranonces. The OP_RETURN pushes 32 bytes.
 func standardCoinbaseOpReturn(enData []byte) ([]byte, error) {
@@ -193,11 +228,9 @@ func BlockVersion(net protocol.Network) uint32 {
 }
 
 func fillWitnessToCoinBase(blockTxns []*types.Tx) error {
-	merkles := merkle.BuildMerkleTreeStore(blockTxns, true)
-	txWitnessRoot := merkles[len(merkles)-1]
-	witnessPreimage := append(txWitnessRoot.Bytes(), blockTxns[0].Tx.TxIn[0].SignScript...)
-	witnessCommitment := hash.DoubleHashH(witnessPreimage[:])
-	blockTxns[0].Tx.TxIn[0].PreviousOut.Hash = witnessCommitment
+	witnessCommitment := merkle.BuildWitnessCommitment(blockTxns,
+		blockTxns[0].Tx.TxIn[0].SignScript)
+	blockTxns[0].Tx.TxIn[0].PreviousOut.Hash = *witnessCommitment
 	blockTxns[0].RefreshHash()
 	return nil
 }
@@ -0,0 +1,44 @@
+package mining
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"testing"
+)
+
+// TestCoinbaseExtraNonceOffset ensures the reported extra nonce region of the
+// coinbase holds the extra nonce and can be overwritten without changing the
+// encoded height.
+func TestCoinbaseExtraNonceOffset(t *testing.T) {
+	for _, height := range []uint64{0, 1, 16, 17, 255, 1 << 20, 1 << 40} {
+		extraNonce := bytes.Repeat([]byte{0xa5}, DefaultCoinbaseExtraNonceSize)
+		script, err := standardCoinbaseScript(height, extraNonce)
+		if err != nil {
+			t.Fatalf("standardCoinbaseScript(%d): %v", height, err)
+		}
+		offset, err := coinbaseExtraNonceOffset(height)
+		if err != nil {
+			t.Fatalf("coinbaseExtraNonceOffset(%d): %v", height, err)
+		}
+		region := script[offset : offset+len(extraNonce)]
+		if !bytes.Equal(region, extraNonce) {
+			t.Fatalf("height %d: region %x, want %x", height, region,
+				extraNonce)
+		}
+
+		copy(region, bytes.Repeat([]byte{0x5a}, len(region)))
+		tx := types.NewTransaction()
+		tx.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(&hash.ZeroHash,
+				types.MaxPrevOutIndex),
+			SignScript: script,
+		})
+		got, err := blockchain.ExtractCoinbaseHeight(tx)
+		if err != nil || got != height {
+			t.Fatalf("height %d: extracted (%d, %v) after overwriting "+
+				"the extra nonce", height, got, err)
+		}
+	}
+}
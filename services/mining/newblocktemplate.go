@@ -1,12 +1,12 @@
 package mining
 
 import (
+	"crypto/rand"
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/merkle"
-	s "github.com/Qitmeer/qitmeer/core/serialization"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/core/types/pow"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
@@ -102,9 +102,10 @@ func NewBlockTemplate(policy *Policy, params *params.Params,
 
 	// Add a random coinbase nonce to ensure that tx prefix hash
 	// so that our merkle root is unique for lookups needed for
-	// getwork, etc.
-	extraNonce, err := s.RandomUint64()
-	if err != nil {
+	// getwork, etc.  Its region in the coinbase is reported with the
+	// template, so miners can overwrite it to produce unique work.
+	extraNonce := make([]byte, policy.coinbaseExtraNonceSize())
+	if _, err := rand.Read(extraNonce); err != nil {
 		return nil, err
 	}
 
@@ -123,6 +124,10 @@ func NewBlockTemplate(policy *Policy, params *params.Params,
 	if err != nil {
 		return nil, err
 	}
+	extraNonceOffset, err := coinbaseExtraNonceOffset(nextBlockHeight)
+	if err != nil {
+		return nil, err
+	}
 	opReturnPkScript, err := standardCoinbaseOpReturn([]byte{})
 	if err != nil {
 		return nil, err
@@ -423,9 +428,9 @@ mempoolLoop:
 	blockVersion := BlockVersion(params.Net)
 
 	// Create a new block ready to be solved.
-	merkles := merkle.BuildMerkleTreeStore(blockTxns, false)
+	merkleRoot := merkle.CalcTxMerkleRootCached(blockTxns, false)
 
-	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	paMerkleRoot := merkle.CalcParentsRoot(parents)
 	var block types.Block
 	var reqDiff uint32
 	switch powType {
@@ -446,8 +451,8 @@ mempoolLoop:
 	}
 	block.Header = types.BlockHeader{
 		Version:    blockVersion,
-		ParentRoot: *paMerkles[len(paMerkles)-1],
-		TxRoot:     *merkles[len(merkles)-1],
+		ParentRoot: *paMerkleRoot,
+		TxRoot:     merkleRoot,
 		StateRoot:  hash.Hash{}, //TODO, state root
 		Timestamp:  ts,
 		Difficulty: reqDiff,
@@ -485,12 +490,14 @@ mempoolLoop:
 		fmt.Sprintf("%064x", pow.CompactToBig(block.Header.Difficulty)))
 
 	blockTemplate := &types.BlockTemplate{
-		Block:           &block,
-		Fees:            txFees,
-		SigOpCounts:     txSigOpCosts,
-		Height:          nextBlockHeight,
-		Blues:           blues,
-		ValidPayAddress: payToAddress != nil,
+		Block:            &block,
+		Fees:             txFees,
+		SigOpCounts:      txSigOpCosts,
+		Height:           nextBlockHeight,
+		Blues:            blues,
+		ValidPayAddress:  payToAddress != nil,
+		ExtraNonceOffset: extraNonceOffset,
+		ExtraNonceSize:   len(extraNonce),
 		PowDiffData: types.PowDiffStandard{
 			Blake2bDTarget:         reqBlake2bDDifficulty,
 			X16rv3DTarget:          reqX16rv3Difficulty,
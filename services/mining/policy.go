@@ -38,4 +38,19 @@ type Policy struct {
 	//
 	// This function must be safe for concurrent access.
 	StandardVerifyFlags func() (txscript.ScriptFlags, error)
+
+	// CoinbaseExtraNonceSize is the size in bytes of the extra nonce region
+	// reserved in the coinbase signature script of generated templates.
+	// Zero selects DefaultCoinbaseExtraNonceSize.
+	CoinbaseExtraNonceSize int
+}
+
+// coinbaseExtraNonceSize returns the size of the extra nonce region to reserve
+// in the coinbase.  The configured size has already been checked against the
+// allowed range when the configuration was loaded.
+func (p *Policy) coinbaseExtraNonceSize() int {
+	if p.CoinbaseExtraNonceSize == 0 {
+		return DefaultCoinbaseExtraNonceSize
+	}
+	return p.CoinbaseExtraNonceSize
 }
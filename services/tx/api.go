@@ -18,6 +18,7 @@ import (
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/index"
 	"github.com/Qitmeer/qitmeer/services/mempool"
 	"time"
 )
@@ -247,6 +248,17 @@ func (api *PublicTxAPI) GetRawTransaction(txHash hash.Hash, verbose bool) (inter
 			return nil, fmt.Errorf("the transaction index " +
 				"must be enabled to query the blockchain (specify --txindex in configuration)")
 		}
+		if verbose {
+			result, err := txIndex.FetchTxVerbose(txHash)
+			if err != nil {
+				context := "Failed to fetch transaction"
+				return nil, rpc.RpcInternalError(err.Error(), context)
+			}
+			if result != nil {
+				return *result, nil
+			}
+		}
+
 		// Look up the location of the transaction.
 		var blockRegion *database.BlockRegion
 		var err error
@@ -878,6 +890,10 @@ func (api *PublicTxAPI) GetRawTransactionByHash(txHash hash.Hash, verbose bool)
 	var err error
 	txid, err = txIndex.GetTxIdByHash(txHash)
 	if err != nil {
+		if !index.IsIndexError(err, index.KindNotFound) {
+			return nil, rpc.RpcInternalError(err.Error(),
+				"Failed to look up transaction id")
+		}
 		if api.txManager.bm.GetChain().CacheInvalidTx {
 			txid, err = txIndex.GetInvalidTxIdByHash(txHash)
 			if err != nil {